@@ -4,6 +4,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/golang-lru/simplelru"
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/metrics"
 	"sync"
 	"time"
 )
@@ -11,7 +12,9 @@ import (
 // Cache is a LRU cache with the following features
 // - limits on max entries
 // - memory size limit
-// - ttl for entries
+// - ttl for entries, which may be shortened per entry
+// - serving of expired entries within their stale windows (RFC 5861)
+// - purging of entries by tags
 type Cache struct {
 	name             string
 	lock             sync.RWMutex
@@ -31,6 +34,63 @@ type CacheEntry struct {
 	fetchTime   time.Time
 	cacheObject interface{}
 	hits        int
+	options     EntryOptions
+}
+
+// EntryOptions are the settings for a single cache entry.
+type EntryOptions struct {
+	// MaxAge is the ttl of the entry, capped by the maxAge of the cache.
+	// If zero, the maxAge of the cache is used. A negative value stores the entry as already expired.
+	MaxAge time.Duration
+
+	// StaleWhileRevalidate is the duration after the expiry of the entry,
+	// in which it may be served, while it is reloaded in the background.
+	StaleWhileRevalidate time.Duration
+
+	// StaleIfError is the duration after the expiry of the entry,
+	// in which it may be served, if reloading fails.
+	StaleIfError time.Duration
+
+	// Tags are the names, by which the entry can be purged together with other entries.
+	Tags []string
+}
+
+// hasTag returns true, if one of the tags is set for the entry.
+func (o EntryOptions) hasTag(tags []string) bool {
+	for _, tag := range tags {
+		for _, entryTag := range o.Tags {
+			if tag == entryTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EntryInfo describes a cache entry for the administration of the cache.
+type EntryInfo struct {
+	Hash      string        `json:"hash"`
+	Label     string        `json:"label"`
+	SizeBytes int           `json:"size_bytes"`
+	Age       time.Duration `json:"age"`
+	Hits      int           `json:"hits"`
+	Tags      []string      `json:"tags,omitempty"`
+}
+
+// maxStale returns the largest duration after expiry, in which the entry may be served.
+func (o EntryOptions) maxStale() time.Duration {
+	if o.StaleWhileRevalidate > o.StaleIfError {
+		return o.StaleWhileRevalidate
+	}
+	return o.StaleIfError
+}
+
+// ttl returns the ttl of an entry, which is never longer than the maxAge of the cache.
+func (c *Cache) ttl(entry *CacheEntry) time.Duration {
+	if entry.options.MaxAge != 0 && entry.options.MaxAge < c.maxAge {
+		return entry.options.MaxAge
+	}
+	return c.maxAge
 }
 
 // NewCache creates a new cache
@@ -68,12 +128,23 @@ func (c *Cache) calculateStats(reportingDuration time.Duration) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.stats = c.currentStats(reportingDuration)
+	c.hits = 0
+	c.misses = 0
+	logging.Logger.
+		WithFields(logrus.Fields(c.stats)).
+		Infof("cache status #%v, %vbytes, %v%% hits", c.lruBackend.Len(), c.currentSizeBytes, c.stats["cache_hit_ratio"])
+}
+
+// currentStats returns the statistics since the last reporting.
+// Attention: This method does not locking.
+func (c *Cache) currentStats(reportingDuration time.Duration) map[string]interface{} {
 	ratio := 100
 	if c.hits+c.misses != 0 {
 		ratio = 100 * c.hits / (c.hits + c.misses)
 	}
 
-	c.stats = map[string]interface{}{
+	return map[string]interface{}{
 		"type":                     "metric",
 		"metric_name":              "cachestatus",
 		"cache_entries":            c.lruBackend.Len(),
@@ -83,12 +154,45 @@ func (c *Cache) calculateStats(reportingDuration time.Duration) {
 		"cache_misses":             c.misses,
 		"cache_hit_ratio":          ratio,
 	}
+}
 
-	c.hits = 0
-	c.misses = 0
-	logging.Logger.
-		WithFields(logrus.Fields(c.stats)).
-		Infof("cache status #%v, %vbytes, %v%% hits", c.lruBackend.Len(), c.currentSizeBytes, ratio)
+// Stats returns the statistics of the last reporting,
+// or the current statistics, if nothing was reported yet.
+func (c *Cache) Stats() map[string]interface{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := c.stats
+	if stats == nil {
+		stats = c.currentStats(0)
+	}
+	result := make(map[string]interface{}, len(stats))
+	for k, v := range stats {
+		result[k] = v
+	}
+	return result
+}
+
+// Entries returns a description of all entries, from the least to the most recently used one.
+func (c *Cache) Entries() []EntryInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	entries := make([]EntryInfo, 0, c.lruBackend.Len())
+	for _, key := range c.lruBackend.Keys() {
+		if e, found := c.lruBackend.Peek(key); found {
+			entry := e.(*CacheEntry)
+			entries = append(entries, EntryInfo{
+				Hash:      entry.key,
+				Label:     entry.label,
+				SizeBytes: entry.size,
+				Age:       time.Since(entry.fetchTime),
+				Hits:      entry.hits,
+				Tags:      entry.options.Tags,
+			})
+		}
+	}
+	return entries
 }
 
 func (c *Cache) Get(key string) (interface{}, bool) {
@@ -97,29 +201,58 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	e, found := c.lruBackend.Get(key)
 	if found {
 		entry := e.(*CacheEntry)
-		if time.Since(entry.fetchTime) < c.maxAge {
+		if time.Since(entry.fetchTime) < c.ttl(entry) {
 			entry.hits++
 			c.hits++
+			metrics.Default.CacheHit(c.name)
 			return entry.cacheObject, true
 		}
 	}
 	c.misses++
+	metrics.Default.CacheMiss(c.name)
 	return nil, false
 }
 
+// GetStale returns an entry, even if it is expired, as long as it was not removed from the cache.
+// The flags tell, whether it is within its stale windows, so it may be served while it is reloaded,
+// or if reloading fails. A fresh entry may be served in both cases.
+// An entry outside of its stale windows may only be used for revalidation.
+func (c *Cache) GetStale(key string) (cacheObject interface{}, staleWhileRevalidate bool, staleIfError bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, found := c.lruBackend.Get(key)
+	if !found {
+		return nil, false, false
+	}
+
+	entry := e.(*CacheEntry)
+	age := time.Since(entry.fetchTime)
+	ttl := c.ttl(entry)
+	staleWhileRevalidate = age < ttl+entry.options.StaleWhileRevalidate
+	staleIfError = age < ttl+entry.options.StaleIfError
+	return entry.cacheObject, staleWhileRevalidate, staleIfError
+}
+
 func (c *Cache) Set(key string, label string, sizeBytes int, cacheObject interface{}) {
+	c.SetWithOptions(key, label, sizeBytes, cacheObject, EntryOptions{})
+}
+
+// SetWithOptions stores an entry with specific settings for this entry.
+func (c *Cache) SetWithOptions(key string, label string, sizeBytes int, cacheObject interface{}, options EntryOptions) {
 	entry := &CacheEntry{
 		key:         key,
 		label:       label,
 		size:        sizeBytes,
 		fetchTime:   time.Now(),
 		cacheObject: cacheObject,
+		options:     options,
 	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	// first remove, to have correct size counting
 	c.lruBackend.Remove(key)
+	entriesBefore := c.lruBackend.Len()
 
 	c.currentSizeBytes += sizeBytes
 	c.lruBackend.Add(key, entry)
@@ -127,6 +260,18 @@ func (c *Cache) Set(key string, label string, sizeBytes int, cacheObject interfa
 	for c.currentSizeBytes > c.maxSizeBytes {
 		c.lruBackend.RemoveOldest()
 	}
+
+	if evicted := entriesBefore + 1 - c.lruBackend.Len(); evicted > 0 {
+		metrics.Default.CacheEviction(c.name, evicted)
+	}
+	metrics.Default.CacheSize(c.name, c.lruBackend.Len(), c.currentSizeBytes)
+}
+
+// reportSize reports the number of entries and the memory size to the metrics.
+func (c *Cache) reportSize() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	metrics.Default.CacheSize(c.name, c.lruBackend.Len(), c.currentSizeBytes)
 }
 
 // called by the cache api, if items are removed,
@@ -138,7 +283,7 @@ func (c *Cache) onEvicted(key, value interface{}) {
 	c.currentSizeBytes -= entry.size
 }
 
-// PurgeOldEntries removes all entries which are out of their ttl
+// PurgeOldEntries removes all entries which are out of their ttl and their stale windows
 func (c *Cache) PurgeOldEntries() {
 	c.lock.RLock()
 	keys := c.lruBackend.Keys()
@@ -151,7 +296,7 @@ func (c *Cache) PurgeOldEntries() {
 
 		if found {
 			entry := e.(*CacheEntry)
-			if time.Since(entry.fetchTime) > c.maxAge {
+			if time.Since(entry.fetchTime) > c.ttl(entry)+entry.options.maxStale() {
 				c.lock.Lock()
 				c.lruBackend.Remove(key)
 				c.lock.Unlock()
@@ -162,6 +307,7 @@ func (c *Cache) PurgeOldEntries() {
 	logging.Logger.
 		WithFields(logrus.Fields(c.stats)).
 		Infof("purged %v out of %v cache entries", purged, len(keys))
+	c.reportSize()
 }
 
 // Purge Entries with a specific hash
@@ -184,6 +330,49 @@ func (c *Cache) PurgeEntries(keys []string) {
 	logging.Logger.
 		WithFields(logrus.Fields(c.stats)).
 		Infof("Following cache entries become purged: %v", c.PurgedKeysAsString(keys))
+	c.reportSize()
+}
+
+// PurgeEntriesByTags removes all entries, which have at least one of the tags
+func (c *Cache) PurgeEntriesByTags(tags []string) {
+	c.lock.RLock()
+	keys := c.lruBackend.Keys()
+	c.lock.RUnlock()
+	purgedKeys := []string{}
+	for _, key := range keys {
+		c.lock.Lock()
+		e, found := c.lruBackend.Peek(key)
+		if found && e.(*CacheEntry).options.hasTag(tags) {
+			c.lruBackend.Remove(key)
+			purgedKeys = append(purgedKeys, key.(string))
+		}
+		c.lock.Unlock()
+	}
+	logging.Logger.
+		WithFields(logrus.Fields(c.stats)).
+		Infof("Following cache entries with tags %v become purged: %v", tags, c.PurgedKeysAsString(purgedKeys))
+	c.reportSize()
+}
+
+// PurgeEntriesByLabel removes all entries, which labels are matched by the function
+func (c *Cache) PurgeEntriesByLabel(match func(label string) bool) {
+	c.lock.RLock()
+	keys := c.lruBackend.Keys()
+	c.lock.RUnlock()
+	purgedKeys := []string{}
+	for _, key := range keys {
+		c.lock.Lock()
+		e, found := c.lruBackend.Peek(key)
+		if found && match(e.(*CacheEntry).label) {
+			c.lruBackend.Remove(key)
+			purgedKeys = append(purgedKeys, key.(string))
+		}
+		c.lock.Unlock()
+	}
+	logging.Logger.
+		WithFields(logrus.Fields(c.stats)).
+		Infof("Following cache entries become purged by label: %v", c.PurgedKeysAsString(purgedKeys))
+	c.reportSize()
 }
 
 func (c *Cache) PurgedKeysAsString(keys []string) string {
@@ -205,6 +394,7 @@ func (c *Cache) Invalidate() {
 	defer c.lock.Unlock()
 	c.lruBackend.Purge()
 	c.currentSizeBytes = 0
+	metrics.Default.CacheSize(c.name, 0, 0)
 	return
 }
 
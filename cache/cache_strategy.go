@@ -7,6 +7,8 @@ import (
 	"github.com/tarent/lib-compose/logging"
 	"github.com/tarent/lib-compose/util"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
@@ -52,9 +54,11 @@ var DefaultIncludeHeaders = []string{"Authorization", "Accept-Encoding", "Host"}
 var DefaultCacheStrategy = NewCacheStrategyWithDefault()
 
 type CacheStrategy struct {
-	includeHeaders []string
-	includeCookies []string
-	ignoreReasons  []cacheobject.Reason
+	includeHeaders       []string
+	includeCookies       []string
+	ignoreReasons        []cacheobject.Reason
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
 }
 
 func NewCacheStrategyWithDefault() *CacheStrategy {
@@ -73,6 +77,14 @@ func NewCacheStrategy(includeHeaders []string, includeCookies []string, ignoreRe
 	}
 }
 
+// WithStaleWindows sets the stale windows (RFC 5861) for responses,
+// which do not have stale-while-revalidate or stale-if-error Cache-Control directives.
+func (tcs *CacheStrategy) WithStaleWindows(staleWhileRevalidate time.Duration, staleIfError time.Duration) *CacheStrategy {
+	tcs.staleWhileRevalidate = staleWhileRevalidate
+	tcs.staleIfError = staleIfError
+	return tcs
+}
+
 // Hash computes a hash value based on the url, the method and selected header and cookie attributes.
 func (tcs *CacheStrategy) Hash(method string, url string, requestHeader http.Header) string {
 	return tcs.HashWithParameters(method, url, requestHeader, tcs.includeHeaders, tcs.includeCookies)
@@ -122,6 +134,63 @@ func (tcs *CacheStrategy) IsCacheable(method string, url string, statusCode int,
 	return true
 }
 
+// EntryOptions returns the settings for the cache entry of a response.
+// The ttl is taken from the s-maxage or max-age Cache-Control directives or the Expires header of the response.
+// The stale windows are taken from the stale-while-revalidate and stale-if-error Cache-Control directives
+// of the response, or from the strategy, if the response does not contain them.
+// The tags are taken from the Surrogate-Key and Cache-Tag headers of the response.
+func (tcs *CacheStrategy) EntryOptions(method string, url string, statusCode int, requestHeader http.Header, responseHeader http.Header) EntryOptions {
+	options := EntryOptions{
+		StaleWhileRevalidate: tcs.staleWhileRevalidate,
+		StaleIfError:         tcs.staleIfError,
+		Tags:                 ReadTags(responseHeader),
+	}
+
+	// the cache is shared between the users, so s-maxage has precedence
+	req := &http.Request{Method: method, Header: requestHeader}
+	_, expiration, err := cacheobject.UsingRequestResponse(req, statusCode, responseHeader, false)
+	if err != nil {
+		logging.Logger.WithError(err).Warnf("error calculating expiration for %v %v: %v", method, url, err)
+	} else if !expiration.IsZero() {
+		options.MaxAge = expiration.Sub(time.Now())
+		if options.MaxAge <= 0 {
+			options.MaxAge = -1
+		}
+	}
+
+	if cacheControl := responseHeader.Get("Cache-Control"); cacheControl != "" {
+		directives, err := cacheobject.ParseResponseCacheControl(cacheControl)
+		if err != nil {
+			logging.Logger.WithError(err).Warnf("error parsing cache control for %v %v: %v", method, url, err)
+			return options
+		}
+		if directives.StaleWhileRevalidate >= 0 {
+			options.StaleWhileRevalidate = time.Duration(directives.StaleWhileRevalidate) * time.Second
+		}
+		if directives.StaleIfError >= 0 {
+			options.StaleIfError = time.Duration(directives.StaleIfError) * time.Second
+		}
+	}
+	return options
+}
+
+// ReadTags returns the cache tags of a response.
+// Surrogate-Key contains space separated tags, Cache-Tag contains comma separated tags.
+func ReadTags(responseHeader http.Header) []string {
+	var tags []string
+	for _, value := range responseHeader[http.CanonicalHeaderKey("Surrogate-Key")] {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	for _, value := range responseHeader[http.CanonicalHeaderKey("Cache-Tag")] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
 func (tcs *CacheStrategy) isReasonIgnorable(reason cacheobject.Reason) bool {
 	for _, ignoreReason := range tcs.ignoreReasons {
 		if reason == ignoreReason {
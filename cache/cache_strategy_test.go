@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
+	"time"
 	"github.com/tarent/lib-compose/util"
 )
 
@@ -196,6 +197,67 @@ func Test_CacheStrategy_IsCachable(t *testing.T) {
 	}
 }
 
+func Test_CacheStrategy_EntryOptions(t *testing.T) {
+	a := assert.New(t)
+
+	strategy := NewCacheStrategyWithDefault().WithStaleWindows(time.Minute, time.Hour)
+
+	// the defaults of the strategy are used without cache control directives
+	options := strategy.EntryOptions("GET", "/foo", 200, nil, http.Header{})
+	a.Equal(time.Minute, options.StaleWhileRevalidate)
+	a.Equal(time.Hour, options.StaleIfError)
+
+	// the directives of the response are preferred
+	options = strategy.EntryOptions("GET", "/foo", 200, nil, http.Header{
+		"Cache-Control": {"max-age=60, stale-while-revalidate=30, stale-if-error=86400"},
+	})
+	a.Equal(30*time.Second, options.StaleWhileRevalidate)
+	a.Equal(24*time.Hour, options.StaleIfError)
+
+	// and the default strategy has no stale windows and ttl
+	options = DefaultCacheStrategy.EntryOptions("GET", "/foo", 200, nil, http.Header{})
+	a.Equal(EntryOptions{}, options)
+}
+
+func Test_CacheStrategy_EntryOptions_MaxAge(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Now()
+	tests := []struct {
+		responseHeader http.Header
+		minMaxAge      time.Duration
+		maxMaxAge      time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=60"}}, 59 * time.Second, 60 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=3600"}}, 3599 * time.Second, 3600 * time.Second},
+		{http.Header{
+			"Date":    {now.UTC().Format(http.TimeFormat)},
+			"Expires": {now.Add(2 * time.Hour).UTC().Format(http.TimeFormat)},
+		}, 7199 * time.Second, 7200 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=0"}}, -1, -1},
+		{http.Header{}, 0, 0},
+	}
+
+	for _, test := range tests {
+		options := DefaultCacheStrategy.EntryOptions("GET", "/foo", 200, nil, test.responseHeader)
+		a.True(options.MaxAge >= test.minMaxAge && options.MaxAge <= test.maxMaxAge,
+			"max age %v for %v", options.MaxAge, test.responseHeader)
+	}
+}
+
+func Test_CacheStrategy_ReadTags(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(ReadTags(http.Header{}))
+	a.Equal([]string{"article-42", "home", "navigation", "footer"}, ReadTags(http.Header{
+		"Surrogate-Key": {"article-42  home"},
+		"Cache-Tag":     {"navigation, footer,"},
+	}))
+
+	options := DefaultCacheStrategy.EntryOptions("GET", "/foo", 200, nil, http.Header{"Cache-Tag": {"article-42"}})
+	a.Equal([]string{"article-42"}, options.Tags)
+}
+
 func Test_CacheStrategy_readCookieValue(t *testing.T) {
 	a := assert.New(t)
 
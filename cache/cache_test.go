@@ -2,6 +2,8 @@ package cache
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/metrics"
+	"strings"
 	"testing"
 	"time"
 )
@@ -133,6 +135,81 @@ func Test_Cache_PurgeOldEntries(t *testing.T) {
 	a.Equal(84, c.SizeByte())
 }
 
+func Test_Cache_EntryMaxAge(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache 50ms ttl
+	c := NewCache("my-cache", 5, 100, time.Millisecond*50)
+
+	// with entries with shorter, longer and the default ttl
+	c.SetWithOptions("short", "", 0, "short", EntryOptions{MaxAge: time.Millisecond})
+	c.SetWithOptions("long", "", 0, "long", EntryOptions{MaxAge: time.Hour})
+	c.SetWithOptions("expired", "", 0, "expired", EntryOptions{MaxAge: -1})
+	c.Set("default", "", 0, "default")
+
+	// then the expired entry is not returned
+	_, found := c.Get("expired")
+	a.False(found)
+
+	// when I wait longer than the short ttl
+	time.Sleep(time.Millisecond * 2)
+
+	// then only the short entry is expired
+	_, found = c.Get("short")
+	a.False(found)
+	_, found = c.Get("default")
+	a.True(found)
+
+	// when I wait longer than the ttl of the cache
+	time.Sleep(time.Millisecond * 50)
+
+	// then the long entry is expired, too, because the ttl of the cache is the maximum
+	_, found = c.Get("long")
+	a.False(found)
+	_, found = c.Get("default")
+	a.False(found)
+}
+
+func Test_Cache_GetStale(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache 1ms ttl
+	c := NewCache("my-cache", 5, 100, time.Millisecond)
+
+	// with entries with different stale windows
+	c.SetWithOptions("revalidate", "", 0, "revalidate", EntryOptions{StaleWhileRevalidate: time.Hour})
+	c.SetWithOptions("error", "", 0, "error", EntryOptions{StaleIfError: time.Hour})
+	c.Set("none", "", 0, "none")
+
+	// when I wait for the TTL
+	time.Sleep(time.Millisecond * 2)
+
+	// then they are not found as fresh entries
+	_, found := c.Get("revalidate")
+	a.False(found)
+
+	// but as stale entries within their windows
+	v, whileRevalidate, ifError := c.GetStale("revalidate")
+	a.Equal("revalidate", v)
+	a.True(whileRevalidate)
+	a.False(ifError)
+
+	v, whileRevalidate, ifError = c.GetStale("error")
+	a.Equal("error", v)
+	a.False(whileRevalidate)
+	a.True(ifError)
+
+	// and entries outside of their stale windows are returned for revalidation
+	v, whileRevalidate, ifError = c.GetStale("none")
+	a.Equal("none", v)
+	a.False(whileRevalidate)
+	a.False(ifError)
+
+	// and the purging keeps the entries within their stale windows
+	c.PurgeOldEntries()
+	a.Equal(2, c.Len())
+}
+
 func Test_Cache_PurgeEntries(t *testing.T) {
 	a := assert.New(t)
 
@@ -149,6 +226,110 @@ func Test_Cache_PurgeEntries(t *testing.T) {
 	a.True(foundInCacheStay)
 }
 
+func Test_Cache_PurgeEntriesByTags(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache with tagged entries
+	c := NewCache("my-cache", 5, 100, time.Hour)
+	c.SetWithOptions("article", "", 0, "article", EntryOptions{Tags: []string{"article-42"}})
+	c.SetWithOptions("teaser", "", 0, "teaser", EntryOptions{Tags: []string{"article-42", "home"}})
+	c.SetWithOptions("navigation", "", 0, "navigation", EntryOptions{Tags: []string{"navigation"}})
+	c.Set("footer", "", 0, "footer")
+
+	// when the entries of a tag are purged
+	c.PurgeEntriesByTags([]string{"article-42"})
+
+	// then only the tagged entries are removed
+	a.Equal(2, c.Len())
+	_, found := c.Get("article")
+	a.False(found)
+	_, found = c.Get("teaser")
+	a.False(found)
+	_, found = c.Get("navigation")
+	a.True(found)
+	_, found = c.Get("footer")
+	a.True(found)
+}
+
+func Test_Cache_PurgeEntriesByLabel(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache with entries
+	c := NewCache("my-cache", 5, 100, time.Hour)
+	c.Set("1", "http://example.de/foo", 0, "foo")
+	c.Set("2", "http://example.de/bar", 0, "bar")
+	c.Set("3", "http://example.org/foo", 0, "foo")
+
+	// when the entries of a host are purged
+	c.PurgeEntriesByLabel(func(label string) bool {
+		return strings.HasPrefix(label, "http://example.de/")
+	})
+
+	// then only the entry of the other host is left
+	entries := c.Entries()
+	a.Equal(1, len(entries))
+	a.Equal("3", entries[0].Hash)
+	a.Equal("http://example.org/foo", entries[0].Label)
+}
+
+func Test_Cache_EntriesAndStats(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache with entries
+	c := NewCache("my-cache", 5, 100, time.Hour)
+	c.SetWithOptions("1", "foo", 10, "foo", EntryOptions{Tags: []string{"a"}})
+	c.Set("2", "bar", 20, "bar")
+	c.Get("1")
+	c.Get("3")
+
+	// then the entries are described from the least recently used one
+	entries := c.Entries()
+	a.Equal(2, len(entries))
+	a.Equal("2", entries[0].Hash)
+	a.Equal(20, entries[0].SizeBytes)
+	a.Equal("1", entries[1].Hash)
+	a.Equal(1, entries[1].Hits)
+	a.Equal([]string{"a"}, entries[1].Tags)
+	a.True(entries[1].Age > 0)
+
+	// and the current stats are returned before the first reporting
+	stats := c.Stats()
+	a.Equal(2, stats["cache_entries"])
+	a.Equal(30, stats["cache_size_bytes"])
+	a.Equal(1, stats["cache_hits"])
+	a.Equal(1, stats["cache_misses"])
+	a.Equal(50, stats["cache_hit_ratio"])
+}
+
+func Test_Cache_Metrics(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recorder for the metrics
+	recorder := metrics.NewPrometheusRecorder()
+	metrics.Default = recorder
+	defer func() {
+		metrics.Default = metrics.NopRecorder{}
+	}()
+
+	// and a cache with max 2 entries
+	c := NewCache("my-cache", 2, 100, time.Hour)
+
+	// when the cache is used
+	c.Set("1", "", 10, "1")
+	c.Set("2", "", 10, "2")
+	c.Set("3", "", 10, "3")
+	c.Get("3")
+	c.Get("1")
+
+	// then the measures are recorded
+	text := string(recorder.Text())
+	a.Contains(text, `lib_compose_cache_hits_total{cache="my-cache"} 1`+"\n")
+	a.Contains(text, `lib_compose_cache_misses_total{cache="my-cache"} 1`+"\n")
+	a.Contains(text, `lib_compose_cache_evictions_total{cache="my-cache"} 1`+"\n")
+	a.Contains(text, `lib_compose_cache_entries{cache="my-cache"} 2`+"\n")
+	a.Contains(text, `lib_compose_cache_size_bytes{cache="my-cache"} 20`+"\n")
+}
+
 func Test_Cache_purgedKeysAsString(t *testing.T) {
 	a := assert.New(t)
 
@@ -1,23 +1,162 @@
 package composition
 
 import (
+	"encoding/json"
 	"github.com/tarent/lib-compose/logging"
 	"net/http"
+	"regexp"
 	"strings"
 )
 
+// CacheAdminPath is the path, below which the CacheInvalidationHandler serves the administration of the cache:
+//
+//	DELETE /internal/cache                        invalidates the whole cache, or only the entries of the tag parameters
+//	GET    /internal/cache/entries                lists the entries
+//	DELETE /internal/cache/entries?prefix=<url>   purges the entries, which labels start with the prefix
+//	DELETE /internal/cache/entries?regex=<regex>  purges the entries, which labels match the regex
+//	DELETE /internal/cache/entries/<hash>         purges one entry
+//	GET    /internal/cache/stats                  shows the statistics
+//
+// Successful invalidations are passed to the next handler, or answered with 204 No Content, if there is none.
+// Other methods and routes are passed to the next handler, like all requests outside of the path.
+//
+// The entries, stats and purge routes expose and drop single entries, which labels may contain e.g. tokens
+// in query strings. So they are refused with 403 Forbidden, unless a hook is set by WithAuthorization.
+// The invalidation of the whole cache stays open without a hook, as before.
+//
+// Earlier versions invalidated the cache on every DELETE request, which path contained "internal/cache".
+// Now the path has to start with the CacheAdminPath.
+const CacheAdminPath = "/internal/cache"
+
 type CacheInvalidationHandler struct {
-	cache Cache
-	next  http.Handler
+	cache     Cache
+	next      http.Handler
+	authorize func(r *http.Request) bool
 }
 
 func (cih *CacheInvalidationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "DELETE" &&
-		strings.Contains(r.URL.EscapedPath(), "internal/cache") &&
-		cih.cache != nil {
+	route, isAdminPath := adminRoute(r.URL.EscapedPath())
+	if !isAdminPath || cih.cache == nil {
+		cih.serveNext(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && route == "/entries":
+		if cih.isAuthorized(w, r, true) {
+			cih.writeJSON(w, r, cih.cache.Entries())
+		}
+	case r.Method == "GET" && route == "/stats":
+		if cih.isAuthorized(w, r, true) {
+			cih.writeJSON(w, r, cih.cache.Stats())
+		}
+	case r.Method == "DELETE" && route == "/entries":
+		if cih.isAuthorized(w, r, true) {
+			cih.purgeByLabel(w, r)
+		}
+	case r.Method == "DELETE" && strings.HasPrefix(route, "/entries/") && !strings.Contains(route[len("/entries/"):], "/"):
+		if cih.isAuthorized(w, r, true) {
+			hash := strings.TrimPrefix(route, "/entries/")
+			logging.Application(r.Header).Infof("cache entry %v was invalidated", hash)
+			cih.cache.PurgeEntries([]string{hash})
+			cih.invalidated(w, r)
+		}
+	case r.Method == "DELETE" && route == "":
+		if cih.isAuthorized(w, r, false) {
+			cih.invalidate(r)
+			cih.invalidated(w, r)
+		}
+	default:
+		cih.serveNext(w, r)
+	}
+}
+
+// adminRoute returns the route below the CacheAdminPath, if the path starts with it.
+// A trailing slash and the leading slash of relative paths are ignored.
+func adminRoute(path string) (string, bool) {
+	path = "/" + strings.TrimPrefix(path, "/")
+	if !strings.HasPrefix(path, CacheAdminPath) {
+		return "", false
+	}
+	route := path[len(CacheAdminPath):]
+	if route != "" && !strings.HasPrefix(route, "/") {
+		return "", false
+	}
+	return strings.TrimSuffix(route, "/"), true
+}
+
+// invalidate purges the entries with the tags of the request, or the whole cache, if no tags are given.
+func (cih *CacheInvalidationHandler) invalidate(r *http.Request) {
+	if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+		logging.Application(r.Header).Infof("cache entries with tags %v were invalidated", tags)
+		cih.cache.PurgeEntriesByTags(tags)
+	} else {
 		logging.Application(r.Header).Info("cache was invalidated")
 		cih.cache.Invalidate()
 	}
+}
+
+func (cih *CacheInvalidationHandler) purgeByLabel(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	expression := r.URL.Query().Get("regex")
+
+	var match func(label string) bool
+	switch {
+	case prefix != "":
+		match = func(label string) bool {
+			return strings.HasPrefix(label, prefix)
+		}
+	case expression != "":
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			http.Error(w, "Error: invalid regex: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		match = regex.MatchString
+	default:
+		http.Error(w, "Error: parameter prefix or regex is required", http.StatusBadRequest)
+		return
+	}
+
+	logging.Application(r.Header).Infof("cache entries matching prefix %q or regex %q were invalidated", prefix, expression)
+	cih.cache.PurgeEntriesByLabel(match)
+	cih.invalidated(w, r)
+}
+
+// invalidated completes a successful invalidation, by passing it to the next handler,
+// e.g. to invalidate further caches, or by answering it with 204 No Content.
+func (cih *CacheInvalidationHandler) invalidated(w http.ResponseWriter, r *http.Request) {
+	if cih.next != nil {
+		cih.next.ServeHTTP(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAuthorized checks the request with the authorization hook.
+// Without a hook, only routes, which do not require one, are allowed.
+func (cih *CacheInvalidationHandler) isAuthorized(w http.ResponseWriter, r *http.Request, requiresHook bool) bool {
+	if cih.authorize == nil && requiresHook {
+		logging.Application(r.Header).Warnf("cache administration %v %v refused, because no authorization is set", r.Method, r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if cih.authorize != nil && !cih.authorize(r) {
+		logging.Application(r.Header).Warnf("unauthorized cache administration %v %v", r.Method, r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (cih *CacheInvalidationHandler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Application(r.Header).WithError(err).Error("error writing cache administration response")
+	}
+}
+
+func (cih *CacheInvalidationHandler) serveNext(w http.ResponseWriter, r *http.Request) {
 	if cih.next != nil {
 		cih.next.ServeHTTP(w, r)
 	}
@@ -26,3 +165,11 @@ func (cih *CacheInvalidationHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 func NewCacheInvalidationHandler(cache Cache, next http.Handler) *CacheInvalidationHandler {
 	return &CacheInvalidationHandler{cache: cache, next: next}
 }
+
+// WithAuthorization sets a hook, which has to allow every request to the administration of the cache.
+// Requests, which are not allowed, are answered with 403 Forbidden.
+// Without a hook, the entries, stats and purge routes are refused.
+func (cih *CacheInvalidationHandler) WithAuthorization(authorize func(r *http.Request) bool) *CacheInvalidationHandler {
+	cih.authorize = authorize
+	return cih
+}
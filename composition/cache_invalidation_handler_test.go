@@ -2,8 +2,11 @@ package composition
 
 import (
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/cache"
 	mockhttp "github.com/tarent/lib-compose/composition/mocks/net/http"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -15,10 +18,29 @@ func Test_CacheInvalidationHandler_Invalidation(t *testing.T) {
 	cacheMocK := NewMockCache(ctrl)
 	cih := NewCacheInvalidationHandler(cacheMocK, nil)
 	request, _ := http.NewRequest(http.MethodDelete, "internal/cache", nil)
+	recorder := httptest.NewRecorder()
 
 	//when
 	cacheMocK.EXPECT().Invalidate().Times(1)
-	cih.ServeHTTP(nil, request)
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	assert.Equal(t, 204, recorder.Code)
+}
+
+func Test_CacheInvalidationHandler_InvalidationByTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	//given
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, nil)
+	request, _ := http.NewRequest(http.MethodDelete, "internal/cache?tag=article-42&tag=navigation", nil)
+
+	//when
+	cacheMocK.EXPECT().PurgeEntriesByTags([]string{"article-42", "navigation"}).Times(1)
+	cacheMocK.EXPECT().Invalidate().Times(0)
+	cih.ServeHTTP(httptest.NewRecorder(), request)
 }
 
 func Test_CacheInvalidationHandler_Delegate_Is_Called(t *testing.T) {
@@ -36,3 +58,210 @@ func Test_CacheInvalidationHandler_Delegate_Is_Called(t *testing.T) {
 	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(1)
 	cih.ServeHTTP(nil, request)
 }
+
+func Test_CacheInvalidationHandler_Routing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	//given
+	handlerMock := mockhttp.NewMockHandler(ctrl)
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, handlerMock).
+		WithAuthorization(func(r *http.Request) bool { return true })
+
+	//when unknown routes are requested, then nothing is purged and the request is passed on
+	for _, path := range []string{"/internal/cache/entrie", "/internal/cache/entries/42/x", "/internal/cache/stats"} {
+		request, _ := http.NewRequest(http.MethodDelete, path, nil)
+		handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+		cih.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	//when unknown methods are requested, then the request is passed on
+	request, _ := http.NewRequest(http.MethodPost, "/internal/cache/entries", nil)
+	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+	cih.ServeHTTP(httptest.NewRecorder(), request)
+
+	//when the path only contains the admin path, then the request is passed on
+	for _, path := range []string{"/app/internal/cache", "/internal/caches"} {
+		request, _ := http.NewRequest(http.MethodDelete, path, nil)
+		handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+		cih.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	//when an entry is purged, then it is passed on like the invalidation of the whole cache
+	request, _ = http.NewRequest(http.MethodDelete, "/internal/cache/entries/42", nil)
+	cacheMocK.EXPECT().PurgeEntries([]string{"42"})
+	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+	cih.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+func Test_CacheInvalidationHandler_ListEntries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, nil).
+		WithAuthorization(func(r *http.Request) bool { return true })
+	request, _ := http.NewRequest(http.MethodGet, "/internal/cache/entries", nil)
+	recorder := httptest.NewRecorder()
+
+	//when
+	cacheMocK.EXPECT().Entries().Return([]cache.EntryInfo{{Hash: "42", Label: "http://example.de/", SizeBytes: 10, Hits: 2}})
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	a.Equal(200, recorder.Code)
+	a.Equal("application/json", recorder.Header().Get("Content-Type"))
+	a.JSONEq(`[{"hash":"42","label":"http://example.de/","size_bytes":10,"age":0,"hits":2}]`, recorder.Body.String())
+}
+
+func Test_CacheInvalidationHandler_Stats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, nil).
+		WithAuthorization(func(r *http.Request) bool { return true })
+	request, _ := http.NewRequest(http.MethodGet, "/internal/cache/stats", nil)
+	recorder := httptest.NewRecorder()
+
+	//when
+	cacheMocK.EXPECT().Stats().Return(map[string]interface{}{"cache_entries": 3})
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	a.Equal(200, recorder.Code)
+	a.JSONEq(`{"cache_entries":3}`, recorder.Body.String())
+}
+
+func Test_CacheInvalidationHandler_PurgeEntryByHash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, nil).
+		WithAuthorization(func(r *http.Request) bool { return true })
+	request, _ := http.NewRequest(http.MethodDelete, "/internal/cache/entries/42", nil)
+	recorder := httptest.NewRecorder()
+
+	//when
+	cacheMocK.EXPECT().PurgeEntries([]string{"42"})
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	a.Equal(204, recorder.Code)
+}
+
+func Test_CacheInvalidationHandler_PurgeEntriesByLabel(t *testing.T) {
+	tests := []struct {
+		query   string
+		code    int
+		matched []string
+	}{
+		{"prefix=http://example.de/", 204, []string{"http://example.de/foo"}},
+		{"regex=.*/foo$", 204, []string{"http://example.de/foo", "http://example.org/foo"}},
+		{"regex=(", 400, nil},
+		{"", 400, nil},
+	}
+	labels := []string{"http://example.de/foo", "http://example.org/foo", "http://example.org/bar"}
+
+	for _, test := range tests {
+		ctrl := gomock.NewController(t)
+		a := assert.New(t)
+
+		//given
+		cacheMocK := NewMockCache(ctrl)
+		cih := NewCacheInvalidationHandler(cacheMocK, nil).
+			WithAuthorization(func(r *http.Request) bool { return true })
+		request, _ := http.NewRequest(http.MethodDelete, "/internal/cache/entries?"+test.query, nil)
+		recorder := httptest.NewRecorder()
+
+		//when
+		var matched []string
+		if test.code == 204 {
+			cacheMocK.EXPECT().PurgeEntriesByLabel(gomock.Any()).
+				Do(func(match func(label string) bool) {
+					for _, label := range labels {
+						if match(label) {
+							matched = append(matched, label)
+						}
+					}
+				})
+		}
+		cih.ServeHTTP(recorder, request)
+
+		//then
+		a.Equal(test.code, recorder.Code, test.query)
+		a.Equal(test.matched, matched, test.query)
+		ctrl.Finish()
+	}
+}
+
+func Test_CacheInvalidationHandler_Authorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given a handler, which only allows requests with a token
+	handlerMock := mockhttp.NewMockHandler(ctrl)
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, handlerMock).
+		WithAuthorization(func(r *http.Request) bool {
+			return r.Header.Get("X-Admin-Token") == "secret"
+		})
+
+	//when the cache is invalidated without token
+	request, _ := http.NewRequest(http.MethodDelete, "/internal/cache", nil)
+	recorder := httptest.NewRecorder()
+	cih.ServeHTTP(recorder, request)
+
+	//then it is forbidden
+	a.Equal(403, recorder.Code)
+
+	//when the cache is invalidated with token
+	request.Header.Set("X-Admin-Token", "secret")
+	cacheMocK.EXPECT().Invalidate()
+	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+	cih.ServeHTTP(httptest.NewRecorder(), request)
+
+	//and other requests are not checked
+	request, _ = http.NewRequest(http.MethodGet, "/some/page", nil)
+	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+	cih.ServeHTTP(httptest.NewRecorder(), request)
+}
+
+func Test_CacheInvalidationHandler_AdministrationRequiresAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given a handler without authorization hook
+	handlerMock := mockhttp.NewMockHandler(ctrl)
+	cacheMocK := NewMockCache(ctrl)
+	cih := NewCacheInvalidationHandler(cacheMocK, handlerMock)
+
+	//when the entries are listed, the stats are shown or entries are purged, then it is forbidden
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/internal/cache/entries"},
+		{http.MethodGet, "/internal/cache/stats"},
+		{http.MethodDelete, "/internal/cache/entries?prefix=http://example.de/"},
+		{http.MethodDelete, "/internal/cache/entries/42"},
+	} {
+		request, _ := http.NewRequest(route.method, route.path, nil)
+		recorder := httptest.NewRecorder()
+		cih.ServeHTTP(recorder, request)
+		a.Equal(403, recorder.Code, route.path)
+	}
+
+	//when the whole cache is invalidated, then it is allowed
+	request, _ := http.NewRequest(http.MethodDelete, "/internal/cache", nil)
+	cacheMocK.EXPECT().Invalidate()
+	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any())
+	cih.ServeHTTP(httptest.NewRecorder(), request)
+}
@@ -2,39 +2,251 @@ package composition
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/tracing"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 type CachingContentLoader struct {
 	httpContentLoader ContentLoader
 	fileContentLoader ContentLoader
 	cache             Cache
+	revalidation      struct {
+		hashes map[string]bool // the hashes of the entries, which are reloaded in background
+		mutex  sync.Mutex
+	}
+	inflight struct {
+		calls map[string]*loadCall // the loads of cache misses, which are shared by all callers
+		mutex sync.Mutex
+	}
+}
+
+// loadCall is one backend load, which is shared by the concurrent callers with the same hash.
+type loadCall struct {
+	done    chan struct{}
+	content Content
+	err     error
+	waiters int  // the callers, which did not give up waiting
+	taken   bool // a stream, which can only be read once, was returned to a caller
+	cancel  context.CancelFunc
 }
 
 func NewCachingContentLoader(cache Cache) *CachingContentLoader {
-	return &CachingContentLoader{
+	loader := &CachingContentLoader{
 		httpContentLoader: NewHttpContentLoader(),
 		fileContentLoader: NewFileContentLoader(),
 		cache:             cache,
 	}
+	loader.revalidation.hashes = make(map[string]bool)
+	loader.inflight.calls = make(map[string]*loadCall)
+	return loader
 }
 
-func (loader *CachingContentLoader) Load(fd *FetchDefinition) (Content, error) {
+// Load returns the content from the cache, or loads and caches it.
+// Expired contents within their stale-while-revalidate window are returned,
+// while one background job reloads them. Expired contents within their stale-if-error
+// window are returned, if reloading fails or returns a server error.
+// Expired contents with an ETag or Last-Modified header are reloaded by a conditional request
+// and reused, if the backend responds with 304 Not Modified.
+// Concurrent cache misses for the same hash are collapsed into one backend call.
+func (loader *CachingContentLoader) Load(ctx context.Context, fd *FetchDefinition) (Content, error) {
 	hash := fd.Hash()
 
+	var expired, staleIfError Content
 	if fd.Method == "GET" && fd.IsReadableFromCache() {
-		if cFromCache, exist := loader.cache.Get(hash); exist {
+		_, span := tracing.Default.Start(ctx, "CachingContentLoader.Lookup")
+		span.SetAttribute("url", fd.URL)
+		cFromCache, exist := loader.cache.Get(hash)
+		span.SetAttribute("hit", exist)
+		span.End()
+
+		if exist {
 			logging.Cacheinfo(fd.URL, true)
 			return cFromCache.(Content), nil
 		}
+		if cStale, whileRevalidate, ifError := loader.cache.GetStale(hash); cStale != nil {
+			expired = cStale.(Content)
+			if whileRevalidate {
+				logging.Cacheinfo(fd.URL, true)
+				loader.revalidateInBackground(ctx, fd, hash, expired)
+				return expired, nil
+			}
+			if ifError {
+				staleIfError = expired
+			}
+		}
 	}
 	logging.Cacheinfo(fd.URL, false)
-	c, err := loader.load(fd)
+	var c Content
+	var err error
+	if fd.Method == "GET" && fd.IsReadableFromCache() {
+		c, err = loader.loadShared(ctx, fd, hash, expired)
+	} else {
+		c, err = loader.loadAndCache(ctx, fd, hash, expired)
+	}
+	if staleIfError != nil && (err != nil || c == nil || c.HttpStatusCode() >= 500) {
+		// the stream of the failed response is not returned, so it has to be closed here
+		closeUnconsumed(c)
+		logging.Logger.WithError(err).
+			WithField("full_url", fd.URL).
+			Warnf("serving stale content for %v, because reloading failed", fd.URL)
+		return staleIfError, nil
+	}
+	return c, err
+}
+
+// loadShared joins a running load for the hash, or starts a new one.
+// Each caller waits only as long as its own context and fetch timeout allow.
+// The backend call is canceled, if all callers gave up waiting.
+func (loader *CachingContentLoader) loadShared(ctx context.Context, fd *FetchDefinition, hash string, expired Content) (Content, error) {
+	loader.inflight.mutex.Lock()
+	call, joined := loader.inflight.calls[hash]
+	if !joined {
+		loadCtx, cancel := context.WithCancel(detachedContext(ctx))
+		call = &loadCall{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		loader.inflight.calls[hash] = call
+
+		definitionCopy := *fd
+		go func() {
+			defer cancel()
+			content, err := loader.loadAndCache(loadCtx, &definitionCopy, hash, expired)
+
+			loader.inflight.mutex.Lock()
+			defer loader.inflight.mutex.Unlock()
+			call.content, call.err = content, err
+			if loader.inflight.calls[hash] == call {
+				delete(loader.inflight.calls, hash)
+			}
+			if call.waiters == 0 {
+				closeUnconsumed(content)
+			}
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	loader.inflight.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if fd.Timeout > 0 {
+		timer := time.NewTimer(fd.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-call.done:
+		// a stream can only be read once, so only the first caller gets it, if it was not cached
+		loader.inflight.mutex.Lock()
+		taken := call.taken
+		call.taken = true
+		loader.inflight.mutex.Unlock()
+		if taken && call.err == nil && !isShareable(call.content) {
+			return loader.loadAndCache(ctx, fd, hash, expired)
+		}
+		return call.content, call.err
+	case <-ctx.Done():
+		loader.leave(hash, call)
+		return nil, ctx.Err()
+	case <-timeout:
+		loader.leave(hash, call)
+		return nil, fmt.Errorf("timeout after %v waiting for loading %q", fd.Timeout, fd.URL)
+	}
+}
+
+// leave removes a waiting caller from the load and cancels it, if no caller is left.
+// If the load is already finished, its stream is closed, because no caller consumes it.
+func (loader *CachingContentLoader) leave(hash string, call *loadCall) {
+	loader.inflight.mutex.Lock()
+	defer loader.inflight.mutex.Unlock()
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		if loader.inflight.calls[hash] == call {
+			delete(loader.inflight.calls, hash)
+		}
+		select {
+		case <-call.done:
+			closeUnconsumed(call.content)
+		default:
+		}
+	}
+}
+
+// closeUnconsumed closes the stream of a content, which is returned to no caller.
+func closeUnconsumed(c Content) {
+	if !isShareable(c) {
+		c.Reader().Close()
+	}
+}
+
+// isShareable returns true, if the content can be returned to more than one caller.
+func isShareable(c Content) bool {
+	if _, isWrapper := c.(*ContentWrapper); isWrapper {
+		return true
+	}
+	return c == nil || c.Reader() == nil
+}
+
+// revalidateInBackground reloads the content for the hash,
+// if it is not already reloaded by another background job.
+func (loader *CachingContentLoader) revalidateInBackground(ctx context.Context, fd *FetchDefinition, hash string, expired Content) {
+	loader.revalidation.mutex.Lock()
+	defer loader.revalidation.mutex.Unlock()
+	if loader.revalidation.hashes[hash] {
+		return
+	}
+	loader.revalidation.hashes[hash] = true
+
+	// the request context may be done before the reloading,
+	// so the background job is only limited by the timeout of the fetch definition
+	loadCtx := detachedContext(ctx)
+	definitionCopy := *fd
+	go func() {
+		defer func() {
+			loader.revalidation.mutex.Lock()
+			delete(loader.revalidation.hashes, hash)
+			loader.revalidation.mutex.Unlock()
+		}()
+		if _, err := loader.loadAndCache(loadCtx, &definitionCopy, hash, expired); err != nil {
+			logging.Logger.WithError(err).
+				WithField("full_url", definitionCopy.URL).
+				Warnf("error revalidating stale content for %v", definitionCopy.URL)
+		}
+	}()
+}
+
+// detachedContext returns a context, which is not canceled with the request, but continues its trace.
+func detachedContext(ctx context.Context) context.Context {
+	return tracing.ContextWithSpanContext(context.Background(), tracing.SpanContextFromContext(ctx))
+}
+
+// loadAndCache loads the content and stores it in the cache.
+// If an expired content is given, its validators are used for a conditional request.
+func (loader *CachingContentLoader) loadAndCache(ctx context.Context, fd *FetchDefinition, hash string, expired Content) (Content, error) {
+	loadDefinition := fd
+	if expired != nil {
+		loadDefinition = conditionalFetchDefinition(fd, expired)
+	}
+	c, err := loader.load(ctx, loadDefinition)
+	if err == nil && expired != nil && c.HttpStatusCode() == http.StatusNotModified {
+		if c.Reader() != nil {
+			c.Reader().Close()
+		}
+		return loader.refresh(fd, hash, expired, c.HttpHeader()), nil
+	}
 	if err == nil {
 		if fd.IsCacheable(c.HttpStatusCode(), c.HttpHeader()) {
+			options := fd.EntryOptions(c.HttpStatusCode(), c.HttpHeader())
 			if c.Reader() != nil {
 				var streamBytes []byte
 				streamBytes, err = ioutil.ReadAll(c.Reader())
@@ -43,22 +255,67 @@ func (loader *CachingContentLoader) Load(fd *FetchDefinition) (Content, error) {
 						Content:     c,
 						streamBytes: streamBytes,
 					}
-					loader.cache.Set(hash, fd.URL, c.MemorySize(), cw)
+					loader.cache.SetWithOptions(hash, fd.URL, c.MemorySize(), cw, options)
 					return cw, nil
 				}
 			} else {
-				loader.cache.Set(hash, fd.URL, c.MemorySize(), c)
+				loader.cache.SetWithOptions(hash, fd.URL, c.MemorySize(), c, options)
 			}
 		}
 	}
 	return c, err
 }
 
-func (loader *CachingContentLoader) load(fd *FetchDefinition) (Content, error) {
+// refresh stores the expired content again with the cache settings of the 304 response,
+// which override the headers of the original response.
+func (loader *CachingContentLoader) refresh(fd *FetchDefinition, hash string, expired Content, notModifiedHeader http.Header) Content {
+	header := http.Header{}
+	for k, v := range expired.HttpHeader() {
+		header[k] = v
+	}
+	for k, v := range notModifiedHeader {
+		header[k] = v
+	}
+	logging.Logger.WithField("full_url", fd.URL).
+		Debugf("reusing cached content for %v, because it was not modified", fd.URL)
+
+	if fd.IsCacheable(expired.HttpStatusCode(), header) {
+		loader.cache.SetWithOptions(hash, fd.URL, expired.MemorySize(), expired, fd.EntryOptions(expired.HttpStatusCode(), header))
+	}
+	return expired
+}
+
+// conditionalFetchDefinition returns a copy of the fetch definition,
+// which requests the content only if it differs from the expired one.
+// The fetch definition is returned unchanged, if the expired content has no validators.
+func conditionalFetchDefinition(fd *FetchDefinition, expired Content) *FetchDefinition {
+	etag := expired.HttpHeader().Get("ETag")
+	lastModified := expired.HttpHeader().Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return fd
+	}
+
+	conditional := *fd
+	conditional.Header = http.Header{}
+	for k, v := range fd.Header {
+		conditional.Header[k] = v
+	}
+	conditional.Header.Del("If-None-Match")
+	conditional.Header.Del("If-Modified-Since")
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+	return &conditional
+}
+
+func (loader *CachingContentLoader) load(ctx context.Context, fd *FetchDefinition) (Content, error) {
 	if strings.HasPrefix(fd.URL, FileURLPrefix) {
-		return loader.fileContentLoader.Load(fd)
+		return loader.fileContentLoader.Load(ctx, fd)
 	}
-	return loader.httpContentLoader.Load(fd)
+	return loader.httpContentLoader.Load(ctx, fd)
 }
 
 type ContentWrapper struct {
@@ -1,13 +1,20 @@
 package composition
 
 import (
+	"context"
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/cache"
+	"github.com/tarent/lib-compose/tracing"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_CacheLoader_Found(t *testing.T) {
@@ -28,7 +35,7 @@ func Test_CacheLoader_Found(t *testing.T) {
 	loader := NewCachingContentLoader(cacheMocK)
 
 	// it is returned
-	result, err := loader.Load(fd)
+	result, err := loader.Load(context.Background(), fd)
 	a.NoError(err)
 	a.Equal(c, result)
 }
@@ -56,8 +63,8 @@ func Test_CacheLoader_No_Cache_Lookup_For_Uncachable_Objects(t *testing.T) {
 	contentMock := NewMockContent(ctrl)
 
 	// and a cache returning the memory content by the hash
-	fileContentLoaderMock.EXPECT().Load(gomock.Any()).Times(0)
-	httpContentLoaderMock.EXPECT().Load(fd).Times(1).Return(contentMock, nil)
+	fileContentLoaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Times(0)
+	httpContentLoaderMock.EXPECT().Load(gomock.Any(), fd).Times(1).Return(contentMock, nil)
 	contentMock.EXPECT().HttpHeader().Times(1).Return(nil)
 	contentMock.EXPECT().HttpStatusCode().Times(1).Return(0)
 	cacheMocK.EXPECT().Get(fd.Hash()).Times(0)
@@ -65,7 +72,7 @@ func Test_CacheLoader_No_Cache_Lookup_For_Uncachable_Objects(t *testing.T) {
 	// when: we load the object
 
 	// it is returned
-	_, err := loader.Load(fd)
+	_, err := loader.Load(context.Background(), fd)
 	a.NoError(err)
 }
 
@@ -83,14 +90,14 @@ func Test_CacheLoader_NoLookupForPostRequests(t *testing.T) {
 	// and a cache returning nothing
 	cacheMocK := NewMockCache(ctrl)
 	httpLoaderMocK := NewMockContentLoader(ctrl)
-	httpLoaderMocK.EXPECT().Load(gomock.Any()).Return(c, nil)
+	httpLoaderMocK.EXPECT().Load(gomock.Any(), gomock.Any()).Return(c, nil)
 
 	// when: we load the object
 	loader := NewCachingContentLoader(cacheMocK)
 	loader.httpContentLoader = httpLoaderMocK
 
 	// it is returned
-	result, err := loader.Load(fd)
+	result, err := loader.Load(context.Background(), fd)
 	a.NoError(err)
 	a.Equal(c, result)
 }
@@ -118,12 +125,13 @@ func Test_CacheLoader_NotFound(t *testing.T) {
 		// and a cache returning nothing
 		cacheMocK := NewMockCache(ctrl)
 		cacheMocK.EXPECT().Get(gomock.Any()).Return(nil, false)
+		cacheMocK.EXPECT().GetStale(gomock.Any()).Return(nil, false, false)
 		if test.cachable {
-			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), c)
+			cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, c.MemorySize(), c, gomock.Any())
 		}
 		// and a loader delegating to
 		loaderMock := NewMockContentLoader(ctrl)
-		loaderMock.EXPECT().Load(gomock.Any()).Return(c, nil)
+		loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(c, nil)
 
 		// when: we load the object
 		loader := NewCachingContentLoader(cacheMocK)
@@ -134,13 +142,408 @@ func Test_CacheLoader_NotFound(t *testing.T) {
 		}
 
 		// it is returned
-		result, err := loader.Load(fd)
+		result, err := loader.Load(context.Background(), fd)
 		a.NoError(err)
 		a.Equal(c, result)
 		ctrl.Finish()
 	}
 }
 
+func Test_CacheLoader_StaleWhileRevalidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	fd := NewFetchDefinition("http://example.de")
+	stale := NewMemoryContent()
+	stale.name = "stale"
+	fresh := NewMemoryContent()
+	fresh.name = "fresh"
+	fresh.httpStatusCode = 200
+
+	// and a cache returning an expired entry within the stale-while-revalidate window
+	reloaded := make(chan bool)
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(stale, true, false)
+	cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, fresh.MemorySize(), fresh, gomock.Any()).
+		Do(func(hash, label string, memorySize int, cacheObject interface{}, options cache.EntryOptions) {
+			close(reloaded)
+		})
+
+	// and a loader, which is called in background
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(fresh, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	result, err := loader.Load(context.Background(), fd)
+
+	// then the stale content is returned
+	a.NoError(err)
+	a.Equal(stale, result)
+
+	// and the fresh content is cached
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		a.Fail("content was not revalidated")
+	}
+}
+
+func Test_CacheLoader_StaleIfError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	fd := NewFetchDefinition("http://example.de")
+	stale := NewMemoryContent()
+	stale.name = "stale"
+
+	// and a cache returning an expired entry within the stale-if-error window
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(stale, false, true)
+
+	// and a failing loader
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(nil, errors.New("some error"))
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	result, err := loader.Load(context.Background(), fd)
+
+	// then the stale content is returned without error
+	a.NoError(err)
+	a.Equal(stale, result)
+}
+
+func Test_CacheLoader_StaleIfErrorClosesStreamOfServerError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	fd := NewFetchDefinition("http://example.de")
+	stale := NewMemoryContent()
+	stale.name = "stale"
+
+	// and a cache returning an expired entry within the stale-if-error window
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(stale, false, true)
+
+	// and a loader, which returns a streaming server error
+	closed := make(chan struct{})
+	c := NewMemoryContent()
+	c.httpStatusCode = 503
+	c.reader = &closeNotifier{Reader: strings.NewReader("Service Unavailable"), closed: closed}
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(c, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	result, err := loader.Load(context.Background(), fd)
+
+	// then the stale content is returned and the stream of the error is closed
+	a.NoError(err)
+	a.Equal(stale, result)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		a.Fail("stream was not closed")
+	}
+}
+
+func Test_CacheLoader_ConditionalRevalidation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	fd := NewFetchDefinition("http://example.de")
+	expired := NewMemoryContent()
+	expired.name = "expired"
+	expired.httpStatusCode = 200
+	expired.httpHeader = http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
+	notModified := NewMemoryContent()
+	notModified.httpStatusCode = 304
+	notModified.httpHeader = http.Header{"Cache-Control": {"max-age=60"}}
+
+	// and a cache returning an expired entry
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(expired, false, false)
+
+	// which is stored again with the ttl of the 304 response
+	cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, expired.MemorySize(), expired, gomock.Any()).
+		Do(func(hash, label string, memorySize int, cacheObject interface{}, options cache.EntryOptions) {
+			a.True(options.MaxAge > 59*time.Second)
+		})
+
+	// and a loader, which is called with a conditional request
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, loadFd *FetchDefinition) {
+			a.Equal(`"v1"`, loadFd.Header.Get("If-None-Match"))
+			a.Equal("Wed, 21 Oct 2015 07:28:00 GMT", loadFd.Header.Get("If-Modified-Since"))
+		}).
+		Return(notModified, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	result, err := loader.Load(context.Background(), fd)
+
+	// then the expired content is reused
+	a.NoError(err)
+	a.Equal(expired, result)
+
+	// and the original fetch definition is unchanged
+	a.Equal("", fd.Header.Get("If-None-Match"))
+}
+
+func Test_CacheLoader_ConditionalRevalidation_Modified(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	fd := NewFetchDefinition("http://example.de")
+	expired := NewMemoryContent()
+	expired.name = "expired"
+	expired.httpHeader = http.Header{"Etag": {`"v1"`}}
+	modified := NewMemoryContent()
+	modified.name = "modified"
+	modified.httpStatusCode = 200
+
+	// and a cache returning an expired entry
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(expired, false, false)
+	cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, modified.MemorySize(), modified, gomock.Any())
+
+	// and a loader returning a modified content
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(modified, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	result, err := loader.Load(context.Background(), fd)
+
+	// then the modified content is returned
+	a.NoError(err)
+	a.Equal(modified, result)
+}
+
+func Test_CacheLoader_ConcurrentMissesAreCoalesced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given:
+	callers := 5
+	fd := NewFetchDefinition("http://example.de")
+	c := NewMemoryContent()
+	c.httpStatusCode = 200
+
+	// and a cache returning nothing
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Times(callers).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Times(callers).Return(nil, false, false)
+	cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, c.MemorySize(), c, gomock.Any())
+
+	// and a loader, which is blocked until all callers are waiting
+	release := make(chan struct{})
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, fd *FetchDefinition) {
+			<-release
+		}).
+		Return(c, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: the object is loaded concurrently
+	results := make(chan Content, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			result, err := loader.Load(context.Background(), fd)
+			a.NoError(err)
+			results <- result
+		}()
+	}
+	for waiters(loader, fd.Hash()) < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	// then all callers get the content of the one backend call
+	for i := 0; i < callers; i++ {
+		a.Equal(c, <-results)
+	}
+}
+
+func Test_CacheLoader_CoalescedLoadRespectsTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a fetch definition with a short timeout
+	fd := NewFetchDefinition("http://example.de")
+	fd.Timeout = time.Millisecond * 10
+
+	// and a cache returning nothing
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(nil, false, false)
+
+	// and a loader, which blocks until it is canceled
+	canceled := make(chan struct{})
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, fd *FetchDefinition) {
+			<-ctx.Done()
+			close(canceled)
+		}).
+		Return(nil, context.Canceled)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: we load the object
+	start := time.Now()
+	_, err := loader.Load(context.Background(), fd)
+
+	// then the caller gets an error after its timeout
+	a.Error(err)
+	a.True(time.Since(start) < time.Second)
+
+	// and the backend call is canceled, because nobody waits for it
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		a.Fail("load was not canceled")
+	}
+}
+
+func Test_CacheLoader_ClosesStreamOfAbandonedLoad(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a fetch definition with a short timeout
+	fd := NewFetchDefinition("http://example.de")
+	fd.Timeout = time.Millisecond * 10
+
+	// and a content with a stream, which is not cacheable
+	closed := make(chan struct{})
+	c := NewMemoryContent()
+	c.httpStatusCode = 200
+	c.httpHeader = http.Header{"Cache-Control": {"no-store"}}
+	c.reader = &closeNotifier{Reader: strings.NewReader("foobar"), closed: closed}
+
+	// and a cache returning nothing
+	cacheMocK := NewMockCache(ctrl)
+	cacheMocK.EXPECT().Get(fd.Hash()).Return(nil, false)
+	cacheMocK.EXPECT().GetStale(fd.Hash()).Return(nil, false, false)
+
+	// and a loader, which returns the content after the caller gave up waiting
+	release := make(chan struct{})
+	loaderMock := NewMockContentLoader(ctrl)
+	loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, fd *FetchDefinition) {
+			<-release
+		}).
+		Return(c, nil)
+
+	loader := NewCachingContentLoader(cacheMocK)
+	loader.httpContentLoader = loaderMock
+
+	// when: the caller times out, before the content is loaded
+	_, err := loader.Load(context.Background(), fd)
+	a.Error(err)
+	close(release)
+
+	// then the stream of the content is closed
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		a.Fail("stream was not closed")
+	}
+}
+
+type closeNotifier struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (cn *closeNotifier) Close() error {
+	close(cn.closed)
+	return nil
+}
+
+func Test_CacheLoader_ContinuesTraceOfRequest(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recording tracer
+	exporter := tracing.NewInMemoryExporter()
+	tracing.Default = tracing.NewRecordingTracer(exporter)
+	defer func() {
+		tracing.Default = tracing.NopTracer{}
+	}()
+
+	// and a server, which records the traceparent
+	traceParent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>the body</body></html>"))
+	}))
+	defer server.Close()
+
+	// and the span of a request
+	ctx, requestSpan := tracing.Default.Start(context.Background(), "request")
+
+	// when a content is loaded by the shared load of the caching loader
+	loader := NewCachingContentLoader(cache.NewCache("test", 100, 10, time.Minute))
+	_, err := loader.Load(ctx, NewFetchDefinition(server.URL))
+	a.NoError(err)
+
+	// then the loading is part of the trace of the request
+	loadSpans := exporter.SpansByName("HttpContentLoader.Load")
+	a.Equal(1, len(loadSpans))
+	a.Equal(requestSpan.SpanContext().TraceID, loadSpans[0].SpanContext.TraceID)
+	a.Equal(requestSpan.SpanContext().SpanID, loadSpans[0].ParentSpanID)
+
+	// and the backend got the span of the loading as parent
+	a.Equal(loadSpans[0].SpanContext.TraceParent(), traceParent)
+}
+
+func waiters(loader *CachingContentLoader, hash string) int {
+	loader.inflight.mutex.Lock()
+	defer loader.inflight.mutex.Unlock()
+	if call, found := loader.inflight.calls[hash]; found {
+		return call.waiters
+	}
+	return 0
+}
+
 func Test_CacheLoader_NotFound_With_Stream(t *testing.T) {
 	tests := []struct {
 		url      string
@@ -166,12 +569,13 @@ func Test_CacheLoader_NotFound_With_Stream(t *testing.T) {
 		// and a cache returning nothing
 		cacheMocK := NewMockCache(ctrl)
 		cacheMocK.EXPECT().Get(gomock.Any()).Return(nil, false)
+		cacheMocK.EXPECT().GetStale(gomock.Any()).Return(nil, false, false)
 		if test.cachable {
-			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), CWMatcher{})
+			cacheMocK.EXPECT().SetWithOptions(fd.Hash(), fd.URL, c.MemorySize(), CWMatcher{}, gomock.Any())
 		}
 		// and a loader delegating to
 		loaderMock := NewMockContentLoader(ctrl)
-		loaderMock.EXPECT().Load(gomock.Any()).Return(c, nil)
+		loaderMock.EXPECT().Load(gomock.Any(), gomock.Any()).Return(c, nil)
 
 		// when: we load the object
 		loader := NewCachingContentLoader(cacheMocK)
@@ -182,7 +586,7 @@ func Test_CacheLoader_NotFound_With_Stream(t *testing.T) {
 		}
 
 		// it is returned
-		result, err := loader.Load(fd)
+		result, err := loader.Load(context.Background(), fd)
 		resultbytes, err := ioutil.ReadAll(result.Reader())
 		resultstring := string(resultbytes)
 		a.NoError(err)
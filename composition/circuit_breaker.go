@@ -0,0 +1,216 @@
+package composition
+
+import (
+	"fmt"
+	"github.com/tarent/lib-compose/logging"
+	"sync"
+	"time"
+)
+
+// DefaultCircuitBreaker is used by all new HttpContentLoaders.
+// It is nil by default, which disables the circuit breaking.
+var DefaultCircuitBreaker *CircuitBreaker
+
+// CircuitBreakerSettings configure, when the circuit of a backend opens and closes again.
+type CircuitBreakerSettings struct {
+	// ErrorRatio is the ratio of failed requests within the window, which opens the circuit.
+	ErrorRatio float64
+
+	// MinRequests is the minimum number of requests within the window, before the circuit may open.
+	MinRequests int
+
+	// Window is the duration, in which the requests are counted.
+	Window time.Duration
+
+	// OpenDuration is the duration, in which all requests fail fast, before probe requests are sent.
+	OpenDuration time.Duration
+
+	// Probes is the number of successful probe requests, which close the circuit again.
+	// Only this number of probe requests is sent concurrently.
+	Probes int
+}
+
+var DefaultCircuitBreakerSettings = CircuitBreakerSettings{
+	ErrorRatio:   0.5,
+	MinRequests:  10,
+	Window:       10 * time.Second,
+	OpenDuration: 5 * time.Second,
+	Probes:       1,
+}
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitOpenError is returned without a request, if the circuit of the backend is open.
+type CircuitOpenError struct {
+	Backend string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %v is open", e.Backend)
+}
+
+// CircuitBreaker stops requests to backends with too many errors.
+// Every backend has its own circuit, which opens, if the ratio of failed requests exceeds the ErrorRatio.
+// After the OpenDuration, probe requests are allowed, which close the circuit on success.
+type CircuitBreaker struct {
+	settings CircuitBreakerSettings
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+// CircuitTicket identifies a request, which was allowed by the CircuitBreaker.
+// Its result has to be reported by Done or Canceled.
+type CircuitTicket struct {
+	backend    string
+	generation int  // the generation of the circuit, when the request was allowed
+	probe      bool // the request was allowed as probe of the half-open circuit
+}
+
+type circuit struct {
+	state          CircuitState
+	generation     int // is increased on every change of the state, so that results of requests of former states are ignored
+	windowStart    time.Time
+	requests       int
+	failures       int
+	openedAt       time.Time
+	probesInFlight int
+	probeSuccesses int
+}
+
+func NewCircuitBreaker(settings CircuitBreakerSettings) *CircuitBreaker {
+	if settings.Probes < 1 {
+		settings.Probes = 1
+	}
+	return &CircuitBreaker{
+		settings: settings,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Allow returns a CircuitOpenError, if no request to the backend should be sent.
+// Every allowed request has to be finished by Done or Canceled with the returned ticket.
+func (cb *CircuitBreaker) Allow(backend string) (CircuitTicket, error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	c := cb.circuit(backend)
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= cb.settings.OpenDuration {
+		c.state = CircuitHalfOpen
+		c.generation++
+		c.probesInFlight = 0
+		c.probeSuccesses = 0
+	}
+
+	ticket := CircuitTicket{backend: backend, generation: c.generation}
+	switch c.state {
+	case CircuitOpen:
+		return ticket, &CircuitOpenError{Backend: backend}
+	case CircuitHalfOpen:
+		if c.probesInFlight >= cb.settings.Probes-c.probeSuccesses {
+			return ticket, &CircuitOpenError{Backend: backend}
+		}
+		c.probesInFlight++
+		ticket.probe = true
+	default:
+		if time.Since(c.windowStart) > cb.settings.Window {
+			c.windowStart = time.Now()
+			c.requests = 0
+			c.failures = 0
+		}
+	}
+	return ticket, nil
+}
+
+// Done records the result of an allowed request.
+// Results of requests, which were allowed before the last change of the state, are ignored,
+// so that e.g. a slow request of the closed circuit does not count as probe.
+func (cb *CircuitBreaker) Done(ticket CircuitTicket, failed bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	backend := ticket.backend
+	c := cb.circuit(backend)
+	if ticket.generation != c.generation {
+		return
+	}
+	switch {
+	case ticket.probe && c.state == CircuitHalfOpen:
+		c.probesInFlight--
+		if failed {
+			cb.open(backend, c)
+			return
+		}
+		c.probeSuccesses++
+		if c.probeSuccesses >= cb.settings.Probes {
+			logging.Logger.WithField("backend", backend).Infof("circuit breaker for %v closed", backend)
+			*c = circuit{state: CircuitClosed, generation: c.generation + 1, windowStart: time.Now()}
+		}
+	case !ticket.probe && c.state == CircuitClosed:
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= cb.settings.MinRequests &&
+			float64(c.failures)/float64(c.requests) >= cb.settings.ErrorRatio {
+			cb.open(backend, c)
+		}
+	}
+}
+
+// Canceled releases an allowed request, which was canceled by the caller,
+// so its result tells nothing about the backend.
+func (cb *CircuitBreaker) Canceled(ticket CircuitTicket) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	c := cb.circuit(ticket.backend)
+	if ticket.probe && ticket.generation == c.generation && c.state == CircuitHalfOpen {
+		c.probesInFlight--
+	}
+}
+
+// State returns the state of the circuit of the backend.
+func (cb *CircuitBreaker) State(backend string) CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.circuit(backend).state
+}
+
+// circuit returns the circuit of the backend.
+// Attention: This method does not locking.
+func (cb *CircuitBreaker) circuit(backend string) *circuit {
+	c, exist := cb.circuits[backend]
+	if !exist {
+		c = &circuit{windowStart: time.Now()}
+		cb.circuits[backend] = c
+	}
+	return c
+}
+
+// open opens the circuit of the backend.
+// Attention: This method does not locking.
+func (cb *CircuitBreaker) open(backend string, c *circuit) {
+	logging.Logger.WithField("backend", backend).
+		Warnf("circuit breaker for %v opened after %v failures of %v requests", backend, c.failures, c.requests)
+	c.state = CircuitOpen
+	c.generation++
+	c.openedAt = time.Now()
+	c.probesInFlight = 0
+	c.probeSuccesses = 0
+}
@@ -0,0 +1,189 @@
+package composition
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_CircuitBreaker_OpensOnErrorRatio(t *testing.T) {
+	a := assert.New(t)
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		ErrorRatio:   0.5,
+		MinRequests:  4,
+		Window:       time.Minute,
+		OpenDuration: time.Minute,
+	})
+
+	// given some requests with less errors than the ratio
+	for _, failed := range []bool{false, false, true} {
+		cb.Done(allow(t, cb, "example.de"), failed)
+	}
+	a.Equal(CircuitClosed, cb.State("example.de"))
+
+	// when the ratio is reached
+	cb.Done(allow(t, cb, "example.de"), true)
+
+	// then the circuit is open
+	a.Equal(CircuitOpen, cb.State("example.de"))
+	_, err := cb.Allow("example.de")
+	a.IsType(&CircuitOpenError{}, err)
+	a.EqualError(err, "circuit breaker for example.de is open")
+
+	// and other backends are not affected
+	allow(t, cb, "example.org")
+	a.Equal(CircuitClosed, cb.State("example.org"))
+}
+
+func Test_CircuitBreaker_HalfOpenProbes(t *testing.T) {
+	a := assert.New(t)
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		ErrorRatio:   1,
+		MinRequests:  1,
+		Window:       time.Minute,
+		OpenDuration: time.Millisecond,
+		Probes:       1,
+	})
+
+	// given an open circuit
+	cb.Done(allow(t, cb, "example.de"), true)
+	a.Equal(CircuitOpen, cb.State("example.de"))
+
+	// when the open duration is over
+	time.Sleep(time.Millisecond * 2)
+
+	// then only one probe request is allowed
+	probe := allow(t, cb, "example.de")
+	a.Equal(CircuitHalfOpen, cb.State("example.de"))
+	_, err := cb.Allow("example.de")
+	a.Error(err)
+
+	// and a failed probe opens the circuit again
+	cb.Done(probe, true)
+	a.Equal(CircuitOpen, cb.State("example.de"))
+
+	// and a canceled probe allows the next probe
+	time.Sleep(time.Millisecond * 2)
+	cb.Canceled(allow(t, cb, "example.de"))
+	probe = allow(t, cb, "example.de")
+
+	// and a successful probe closes the circuit
+	cb.Done(probe, false)
+	a.Equal(CircuitClosed, cb.State("example.de"))
+	allow(t, cb, "example.de")
+}
+
+func Test_CircuitBreaker_WindowResetsCounts(t *testing.T) {
+	a := assert.New(t)
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		ErrorRatio:   0.5,
+		MinRequests:  2,
+		Window:       time.Millisecond,
+		OpenDuration: time.Minute,
+	})
+
+	cb.Done(allow(t, cb, "example.de"), true)
+	time.Sleep(time.Millisecond * 2)
+
+	cb.Done(allow(t, cb, "example.de"), false)
+	a.Equal(CircuitClosed, cb.State("example.de"))
+}
+
+func Test_CircuitBreaker_IgnoresResultsOfFormerStates(t *testing.T) {
+	a := assert.New(t)
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{
+		ErrorRatio:   1,
+		MinRequests:  1,
+		Window:       time.Minute,
+		OpenDuration: time.Millisecond,
+		Probes:       1,
+	})
+
+	// given a slow request, which was allowed by the closed circuit
+	slow := allow(t, cb, "example.de")
+
+	// and a circuit, which opened and is half-open now
+	cb.Done(allow(t, cb, "example.de"), true)
+	time.Sleep(time.Millisecond * 2)
+	probe := allow(t, cb, "example.de")
+
+	// when the slow request succeeds or is canceled
+	cb.Done(slow, false)
+	cb.Canceled(slow)
+
+	// then it does not count as probe
+	a.Equal(CircuitHalfOpen, cb.State("example.de"))
+	_, err := cb.Allow("example.de")
+	a.Error(err)
+
+	// and only the probe closes the circuit
+	cb.Done(probe, false)
+	a.Equal(CircuitClosed, cb.State("example.de"))
+
+	// and a late result of the probe does not affect the closed circuit
+	cb.Done(probe, true)
+	a.Equal(CircuitClosed, cb.State("example.de"))
+}
+
+func allow(t *testing.T, cb *CircuitBreaker, backend string) CircuitTicket {
+	ticket, err := cb.Allow(backend)
+	assert.NoError(t, err)
+	return ticket
+}
+
+func Test_HttpContentLoader_CircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	// given a failing server
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "Internal Server Error", 500)
+	}))
+	defer server.Close()
+
+	// and a loader with a circuit breaker
+	loader := NewHttpContentLoader().WithCircuitBreaker(NewCircuitBreaker(CircuitBreakerSettings{
+		ErrorRatio:   0.5,
+		MinRequests:  2,
+		Window:       time.Minute,
+		OpenDuration: time.Minute,
+	}))
+
+	// when the errors reach the ratio
+	for i := 0; i < 2; i++ {
+		_, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
+		a.Error(err)
+	}
+
+	// then the next request fails fast without calling the server
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
+	a.IsType(&CircuitOpenError{}, err)
+	a.Equal(502, c.HttpStatusCode())
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+}
+
+func Test_HttpContentLoader_CircuitBreakerIgnoresCanceledRequests(t *testing.T) {
+	a := assert.New(t)
+
+	server := testServer("the body", time.Millisecond*50)
+	defer server.Close()
+
+	cb := NewCircuitBreaker(CircuitBreakerSettings{ErrorRatio: 0.5, MinRequests: 1, Window: time.Minute, OpenDuration: time.Minute})
+	loader := NewHttpContentLoader().WithCircuitBreaker(cb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+	_, err := loader.Load(ctx, NewFetchDefinition(server.URL))
+	a.Error(err)
+
+	a.Equal(CircuitClosed, cb.State(backendName(server.URL)))
+}
@@ -2,8 +2,10 @@ package composition
 
 import (
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/tracing"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,6 +18,8 @@ type CompositionHandler struct {
 	contentFetcherFactory ContentFetcherFactory
 	contentMergerFactory  func(metaJSON map[string]interface{}) ContentMerger
 	cache                 Cache
+	streaming             bool
+	cspNonce              bool
 }
 
 // NewCompositionHandler creates a new Handler with the supplied defaultData,
@@ -40,6 +44,25 @@ func NewCompositionHandlerWithCache(contentFetcherFactory ContentFetcherFactory,
 	}
 }
 
+// WithStreaming enables the streaming of the composed page.
+// The head and the layout are written as soon as the first and all required
+// fetch jobs, known at request time, are done. Fragments of further contents are written,
+// as soon as their fetch jobs are done. Because the status code is already sent at that time,
+// errors of later fetch jobs and Set-Cookie headers of later contents can not be forwarded to the client.
+// Streaming is only used, if the fetcher is a FetchResultStreamer and the merger a StreamingContentMerger.
+func (agg *CompositionHandler) WithStreaming() *CompositionHandler {
+	agg.streaming = true
+	return agg
+}
+
+// WithCSPNonce enables a random nonce for each request, which is added to the script-src and style-src
+// of the merged Content-Security-Policy and to all script and style elements of the composed page.
+// Within the templates, the nonce is available as §[ csp.nonce ]§.
+func (agg *CompositionHandler) WithCSPNonce() *CompositionHandler {
+	agg.cspNonce = true
+	return agg
+}
+
 func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// If we know the host but don't have the Host header [any more] then we
 	// set [or restore] the header, because why would You just remove it!?:
@@ -47,12 +70,39 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		r.Header.Set("Host", r.Host)
 	}
 
+	// the span of the composition is the parent of all fetches,
+	// if the fetcher is created with the context of the request
+	ctx, span := tracing.Default.Start(tracing.Extract(r.Context(), r.Header), "CompositionHandler.ServeHTTP")
+	defer span.End()
+	span.SetAttribute("path", r.URL.Path)
+	r = r.WithContext(ctx)
+
 	fetcher := agg.contentFetcherFactory(r)
 
 	if agg.handleEmptyFetcher(fetcher, w, r) {
 		return
 	}
 
+	nonce, err := agg.createNonce()
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Errorf("error creating the csp nonce: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+
+	// the merger is created once with an empty map, which is filled, when the results are known
+	metaJSON := make(map[string]interface{})
+	mergeContext := agg.contentMergerFactory(metaJSON)
+
+	if agg.streaming && r.Method != "HEAD" {
+		if streamer, ok := fetcher.(FetchResultStreamer); ok {
+			if streamingMergeContext, ok := mergeContext.(StreamingContentMerger); ok {
+				agg.serveStreaming(streamer, streamingMergeContext, metaJSON, nonce, w, r)
+				return
+			}
+		}
+	}
+
 	// fetch all contents
 	results := fetcher.WaitForResults()
 
@@ -61,7 +111,14 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	mergeContext := agg.contentMergerFactory(fetcher.MetaJSON())
+	fetchedMetaJSON := fetcher.MetaJSON()
+	if nonce != "" {
+		fetchedMetaJSON = cspMetaJSON(fetchedMetaJSON, nonce)
+	}
+	for k, v := range fetchedMetaJSON {
+		metaJSON[k] = v
+	}
+	setNonce(mergeContext, nonce)
 
 	for _, res := range results {
 		if res.Err == nil && res.Content != nil {
@@ -83,7 +140,7 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 	status := agg.extractStatusCode(results, w, r)
 
-	agg.copyHeadersIfNeeded(results, w, r)
+	agg.copyHeadersIfNeeded(results, nonce, w, r)
 
 	// Overwrite Content-Type to ensure, that the encoding is correct
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -100,6 +157,109 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	w.Write(html)
 }
 
+// serveStreaming writes the composed page, while the fetch jobs are still in progress.
+// The metaJSON has to be the map, the mergeContext was created with.
+func (agg *CompositionHandler) serveStreaming(fetcher FetchResultStreamer, mergeContext StreamingContentMerger, metaJSON map[string]interface{}, nonce string, w http.ResponseWriter, r *http.Request) {
+	setNonce(mergeContext, nonce)
+
+	var finished []*FetchResult
+	complete := false
+	handled := make(map[*FetchResult]bool)
+
+	waitForNewResults := func() bool {
+		if complete {
+			return false
+		}
+		var currentMetaJSON map[string]interface{}
+		finished, currentMetaJSON, complete = fetcher.WaitForNewResults(len(finished))
+		if nonce != "" {
+			currentMetaJSON = cspMetaJSON(currentMetaJSON, nonce)
+		}
+		for k, v := range currentMetaJSON {
+			metaJSON[k] = v
+		}
+		return true
+	}
+
+	// wait for the jobs, which decide about the status code, the headers and the error handling
+	initial := fetcher.ScheduledResults()
+	for !initialResultsFinished(initial, finished) && waitForNewResults() {
+	}
+
+	results := make([]*FetchResult, 0, len(initial))
+	for _, res := range initial {
+		if containsResult(finished, res) {
+			results = append(results, res)
+			handled[res] = true
+		}
+	}
+	if hasPrioritySetting(results) {
+		sort.Stable(FetchResults(results))
+	}
+
+	for _, res := range results {
+		if res.Err == nil && res.Content != nil {
+			if agg.handleNonMergeableResponses(res, w, r) {
+				return
+			}
+			mergeContext.AddContent(res.Content, res.Def.Priority)
+		} else if res.Def.Required {
+			LogFetchResultLoadingError(res, w, r)
+			return
+		} else {
+			logging.Application(r.Header).WithField("fetchResult", res).Warnf("optional content not loaded: %v", res.Def.URL)
+		}
+	}
+
+	status := agg.extractStatusCode(results, w, r)
+	agg.copyHeadersIfNeeded(results, nonce, w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	awaitContent := func() bool {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if !waitForNewResults() {
+			return false
+		}
+		for _, res := range finished {
+			if !handled[res] {
+				handled[res] = true
+				agg.addStreamedResult(mergeContext, res, r)
+			}
+		}
+		return true
+	}
+
+	_, span := tracing.Default.Start(r.Context(), "ContentMerge.WriteHtml")
+	defer span.End()
+	if err := mergeContext.WriteHtml(w, awaitContent); err != nil {
+		span.SetError(err)
+		// the status code is already written, so we can only stop here
+		logging.Application(r.Header).WithError(err).Errorf("error while streaming the composition: %v", err)
+		agg.purgeCacheEntries(finished)
+	}
+}
+
+// addStreamedResult adds the content of a fetch result, which was done after the response was started.
+func (agg *CompositionHandler) addStreamedResult(mergeContext ContentMerger, res *FetchResult, r *http.Request) {
+	if res.Err != nil || res.Content == nil {
+		if res.Def.Required {
+			logging.Application(r.Header).WithField("fetchResult", res).Errorf("required content not loaded, while streaming: %v", res.Def.URL)
+		} else {
+			logging.Application(r.Header).WithField("fetchResult", res).Warnf("optional content not loaded: %v", res.Def.URL)
+		}
+		return
+	}
+	if res.Content.Reader() != nil {
+		res.Content.Reader().Close()
+		logging.Application(r.Header).WithField("fetchResult", res).Warnf("stream content can not be merged, while streaming: %v", res.Def.URL)
+		return
+	}
+	mergeContext.AddContent(res.Content, res.Def.Priority)
+}
+
 // Purge the documents with the supplied hashes out of the cache.
 func (agg *CompositionHandler) purgeCacheEntries(results []*FetchResult) {
 	if agg.cache != nil {
@@ -134,7 +294,7 @@ func (agg *CompositionHandler) extractStatusCode(results []*FetchResult, w http.
 	return 200
 }
 
-func (agg *CompositionHandler) copyHeadersIfNeeded(results []*FetchResult, w http.ResponseWriter, r *http.Request) {
+func (agg *CompositionHandler) copyHeadersIfNeeded(results []*FetchResult, nonce string, w http.ResponseWriter, r *http.Request) {
 	// Take headers from first fetch definition
 	if len(results) > 0 {
 		copyHeaders(results[0].Content.HttpHeader(), w.Header(), ForwardResponseHeaders)
@@ -146,10 +306,56 @@ func (agg *CompositionHandler) copyHeadersIfNeeded(results []*FetchResult, w htt
 			copyHeaders(r.Content.HttpHeader(), w.Header(), []string{"Set-Cookie"})
 		}
 	}
+
+	// The Content-Security-Policy has to allow the resources of all merged contents
+	if contains(ForwardResponseHeaders, ContentSecurityPolicyHeader) {
+		agg.mergeContentSecurityPolicies(results, nonce, w)
+	}
+}
+
+// mergeContentSecurityPolicies sets the Content-Security-Policy, merged out of the policies of all loaded contents.
+// If a nonce is given, it is added to the merged policies.
+func (agg *CompositionHandler) mergeContentSecurityPolicies(results []*FetchResult, nonce string, w http.ResponseWriter) {
+	contents := make([][]ContentSecurityPolicy, 0, len(results))
+	for _, res := range results {
+		if res.Err != nil || res.Content == nil {
+			continue
+		}
+		if values := res.Content.HttpHeader()[ContentSecurityPolicyHeader]; len(values) > 0 {
+			contents = append(contents, ParseContentSecurityPolicies(values...))
+		}
+	}
+	w.Header().Del(ContentSecurityPolicyHeader)
+
+	for _, merged := range MergeContentSecurityPoliciesOfContents(contents...) {
+		if nonce != "" {
+			merged.AddNonce(nonce)
+		}
+		w.Header().Add(ContentSecurityPolicyHeader, merged.String())
+	}
+}
+
+// createNonce returns a new nonce, if the csp nonce is enabled, or an empty string otherwise.
+func (agg *CompositionHandler) createNonce() (string, error) {
+	if !agg.cspNonce {
+		return "", nil
+	}
+	return newCSPNonce()
+}
+
+func setNonce(mergeContext ContentMerger, nonce string) {
+	if nonceMerger, ok := mergeContext.(NonceContentMerger); ok && nonce != "" {
+		nonceMerger.SetNonce(nonce)
+	}
 }
 
 func (agg *CompositionHandler) processHtml(mergeContext ContentMerger, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	_, span := tracing.Default.Start(r.Context(), "ContentMerge.GetHtml")
 	html, err := mergeContext.GetHtml()
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
 	if err != nil {
 		logging.Application(r.Header).Error(err.Error())
 		http.Error(w, "Internal Server Error: "+err.Error(), 500)
@@ -243,6 +449,25 @@ func hasPrioritySetting(results []*FetchResult) bool {
 	return false
 }
 
+// initialResultsFinished checks, if the first and all required results of the initial jobs are finished.
+func initialResultsFinished(initial []*FetchResult, finished []*FetchResult) bool {
+	for i, res := range initial {
+		if (i == 0 || res.Def.Required) && !containsResult(finished, res) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsResult(list []*FetchResult, item *FetchResult) bool {
+	for _, v := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(list []string, item string) bool {
 	for _, v := range list {
 		if item == v {
@@ -1,11 +1,13 @@
 package composition
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/tarent/lib-compose/cache"
+	"github.com/tarent/lib-compose/tracing"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -133,6 +135,94 @@ func Test_CompositionHandler_CorrectHeaderAndStatusCodeReturned(t *testing.T) {
 	a.Contains(resp.Header()["Set-Cookie"], "cookie-content 3")
 }
 
+func Test_CompositionHandler_MergesContentSecurityPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	optional := NewFetchDefinition("/baz")
+	optional.Required = false
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def: NewFetchDefinition("/foo"),
+				Content: &MemoryContent{
+					body: map[string]Fragment{
+						"": StringFragment("Hello World"),
+					},
+					httpHeader: http.Header{
+						"Content-Security-Policy": {"default-src 'self'; img-src *"},
+					},
+					httpStatusCode: 200,
+				},
+			},
+			&FetchResult{
+				Def: NewFetchDefinition("/bar"),
+				Content: &MemoryContent{
+					httpHeader: http.Header{
+						"Content-Security-Policy": {"script-src https://cdn.example.com"},
+					},
+				},
+			},
+			&FetchResult{
+				Def: optional,
+				Content: &MemoryContent{
+					httpHeader: http.Header{
+						"Content-Security-Policy": {"script-src https://not-loaded.example.com"},
+					},
+				},
+				Err: errors.New("optional content not loaded"),
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Equal([]string{"default-src 'self'; img-src *; script-src 'self' https://cdn.example.com"}, resp.Header()["Content-Security-Policy"])
+}
+
+func Test_CompositionHandler_CSPNonce(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def: NewFetchDefinition("/foo"),
+				Content: &MemoryContent{
+					head: StringFragment(`<style>p {}</style>`),
+					body: map[string]Fragment{
+						"": StringFragment(`<script>init()</script><p data-nonce="§[ csp.nonce ]§"></p>`),
+					},
+					httpHeader: http.Header{
+						"Content-Security-Policy": {"script-src 'self'; style-src 'self'"},
+					},
+					httpStatusCode: 200,
+				},
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithCSPNonce()
+
+	nonces := []string{}
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://example.com", nil)
+		ch.ServeHTTP(resp, r)
+
+		a.Equal(200, resp.Code)
+		csp := resp.Header().Get("Content-Security-Policy")
+		nonce := strings.SplitN(strings.SplitN(csp, "'nonce-", 2)[1], "'", 2)[0]
+		a.Equal("script-src 'self' 'nonce-"+nonce+"'; style-src 'self' 'nonce-"+nonce+"'", csp)
+		a.Contains(resp.Body.String(), `<style nonce="`+nonce+`">p {}</style>`)
+		a.Contains(resp.Body.String(), `<script nonce="`+nonce+`">init()</script><p data-nonce="`+nonce+`"></p>`)
+		nonces = append(nonces, nonce)
+	}
+	a.NotEqual(nonces[0], nonces[1])
+}
+
 func Test_CompositionHandler_CorrectHeaderAndStatusCodeReturned_onRedirect(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -515,6 +605,134 @@ func Test_CompositionHandler_RestoreHostHeader(t *testing.T) {
 }
 
 
+func Test_CompositionHandler_Streaming(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	layoutFd := NewFetchDefinition("/layout").WithName(LayoutFragmentName)
+	slowFd := NewFetchDefinition("/slow").WithName("slow")
+	slowFd.Required = false
+
+	loader := NewMockContentLoader(ctrl)
+	loader.EXPECT().Load(gomock.Any(), layoutFd).Return(&MemoryContent{
+		name:           LayoutFragmentName,
+		head:           StringFragment("<title>streamed</title>"),
+		httpStatusCode: 200,
+		body: map[string]Fragment{
+			"": StringFragment("<main>§[#> slow]§§[/slow]§</main>"),
+		},
+	}, nil)
+	loader.EXPECT().Load(gomock.Any(), slowFd).
+		Do(func(ctx context.Context, fd *FetchDefinition) {
+			time.Sleep(time.Millisecond * 10)
+		}).
+		Return(&MemoryContent{
+			name:           "slow",
+			httpStatusCode: 200,
+			body: map[string]Fragment{
+				"": StringFragment("Hello Slow World"),
+			},
+		}, nil)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcher(nil)
+		fetcher.Loader = loader
+		fetcher.AddFetchJob(layoutFd)
+		fetcher.AddFetchJob(slowFd)
+		return fetcher
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithStreaming()
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	expected := `<!DOCTYPE html>
+<html>
+  <head>
+    <title>streamed</title>
+  </head>
+  <body>
+    <main>Hello Slow World</main>
+  </body>
+</html>
+`
+	a.Equal(expected, string(resp.Body.Bytes()))
+	a.Equal(200, resp.Code)
+	a.True(resp.Flushed)
+	a.Equal("", resp.Header().Get("Content-Length"))
+	a.Equal("text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+}
+
+func Test_CompositionHandler_StreamingFallbackForNonStreamingSupplier(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def: NewFetchDefinition("/foo"),
+				Content: &MemoryContent{
+					body: map[string]Fragment{
+						"": StringFragment("Hello World"),
+					},
+				},
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithStreaming()
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.False(resp.Flushed)
+	a.NotEqual("", resp.Header().Get("Content-Length"))
+	a.Contains(string(resp.Body.Bytes()), "Hello World")
+}
+
+func Test_CompositionHandler_StreamingFallbackForNonStreamingMerger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a streaming fetcher and a merger, which is not able to stream
+	layoutFd := NewFetchDefinition("/layout").WithName(LayoutFragmentName)
+	loader := NewMockContentLoader(ctrl)
+	loader.EXPECT().Load(gomock.Any(), layoutFd).Return(&MemoryContent{
+		name:           LayoutFragmentName,
+		httpStatusCode: 200,
+		body: map[string]Fragment{
+			"": StringFragment("Hello World"),
+		},
+	}, nil)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcher(nil)
+		fetcher.Loader = loader
+		fetcher.AddFetchJob(layoutFd)
+		return fetcher
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithStreaming()
+	mergerCount := 0
+	ch.contentMergerFactory = func(metaJSON map[string]interface{}) ContentMerger {
+		mergerCount++
+		return struct{ ContentMerger }{NewContentMerge(metaJSON)}
+	}
+
+	// when the page is requested
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	// then the page is composed without streaming by the only merger
+	a.Equal(1, mergerCount)
+	a.Equal(200, resp.Code)
+	a.False(resp.Flushed)
+	a.Contains(string(resp.Body.Bytes()), "Hello World")
+}
+
 type MockFetchResultSupplier []*FetchResult
 
 func (m MockFetchResultSupplier) WaitForResults() []*FetchResult {
@@ -528,3 +746,52 @@ func (m MockFetchResultSupplier) MetaJSON() map[string]interface{} {
 func (m MockFetchResultSupplier) Empty() bool {
 	return len([]*FetchResult(m)) == 0
 }
+
+func Test_CompositionHandler_Tracing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a recording tracer
+	exporter := tracing.NewInMemoryExporter()
+	tracing.Default = tracing.NewRecordingTracer(exporter)
+	defer func() {
+		tracing.Default = tracing.NopTracer{}
+	}()
+
+	// and a fetcher with the context of the request
+	content := NewMemoryContent()
+	content.body[""] = StringFragment("Hello World\n")
+	loader := NewMockContentLoader(ctrl)
+	loader.EXPECT().Load(gomock.Any(), gomock.Any()).Return(content, nil)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcherWithContext(r.Context(), nil)
+		fetcher.Loader = loader
+		fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+		return fetcher
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+
+	// when a request with a traceparent is composed
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ch.ServeHTTP(resp, r)
+	a.Equal(200, resp.Code)
+
+	// then the composition continues the trace of the request
+	remote, _ := tracing.ParseTraceParent(r.Header.Get("traceparent"))
+	handlerSpans := exporter.SpansByName("CompositionHandler.ServeHTTP")
+	a.Equal(1, len(handlerSpans))
+	a.Equal(remote.TraceID, handlerSpans[0].SpanContext.TraceID)
+	a.Equal(remote.SpanID, handlerSpans[0].ParentSpanID)
+
+	// and the fetches and the merging are children of the composition
+	for _, name := range []string{"ContentFetcher.AddFetchJob", "ContentMerge.GetHtml"} {
+		spans := exporter.SpansByName(name)
+		a.Equal(1, len(spans), name)
+		a.Equal(handlerSpans[0].SpanContext.SpanID, spans[0].ParentSpanID, name)
+	}
+	a.Equal("/foo", exporter.SpansByName("ContentFetcher.AddFetchJob")[0].Attributes["url"])
+}
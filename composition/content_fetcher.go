@@ -1,10 +1,14 @@
 package composition
 
 import (
+	"context"
 	"errors"
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/metrics"
+	"github.com/tarent/lib-compose/tracing"
 	"sort"
 	"sync"
+	"time"
 )
 
 type FetchResult struct {
@@ -37,36 +41,62 @@ type FetchDefinitionFactory func(name string, params Params) (fd *FetchDefinitio
 type ContentFetcher struct {
 	activeJobs sync.WaitGroup
 	r          struct {
-		sheduledFetchDefinitionNames map[string]string
+		sheduledFetchDefinitionNames map[string]string // name -> hash
 		results                      []*FetchResult
+		finished                     []*FetchResult // results of done jobs in the order of completion
+		finishedCond                 *sync.Cond
+		rootHashes                   []string            // hashes of the jobs added by AddFetchJob
+		dependencyHashes             map[string][]string // hash -> hashes of the dependent jobs
 		mutex                        sync.Mutex
 	}
 	meta struct {
-		json  map[string]interface{}
-		mutex sync.Mutex
+		defaults map[string]interface{}            // the default meta JSON
+		byHash   map[string]map[string]interface{} // the meta JSON of each loaded content
+		mutex    sync.Mutex
 	}
-	lazyFdFactory FetchDefinitionFactory
-	Loader        ContentLoader
+	lazyFdFactory  FetchDefinitionFactory
+	ctx            context.Context
+	optionalCtx    context.Context // the context for optional jobs, which may end earlier
+	cancelOptional context.CancelFunc
+	Loader         ContentLoader
 }
 
 // NewContentFetcher creates a ContentFetcher with an HtmlContentParser as default.
-// TODO: The FetchResults should always be returned in a predictable order,
-// independent of the actual response times of the fetch jobs.
 func NewContentFetcher(defaultMetaJSON map[string]interface{}) *ContentFetcher {
+	return NewContentFetcherWithContext(context.Background(), defaultMetaJSON)
+}
+
+// NewContentFetcherWithContext creates a ContentFetcher, which loads all contents within the supplied context.
+// The context should be derived from the incoming request, so that the fetch jobs are canceled,
+// if the client disconnects. A deadline of the context bounds the whole composition.
+func NewContentFetcherWithContext(ctx context.Context, defaultMetaJSON map[string]interface{}) *ContentFetcher {
 	f := &ContentFetcher{}
+	f.ctx = ctx
+	f.optionalCtx = ctx
 	f.r.results = make([]*FetchResult, 0, 0)
 	f.r.sheduledFetchDefinitionNames = make(map[string]string)
+	f.r.finishedCond = sync.NewCond(&f.r.mutex)
+	f.r.dependencyHashes = make(map[string][]string)
 	f.Loader = NewHttpContentLoader()
-	f.meta.json = defaultMetaJSON
-	if f.meta.json == nil {
-		f.meta.json = make(map[string]interface{})
+	f.meta.defaults = make(map[string]interface{}, len(defaultMetaJSON))
+	for k, v := range defaultMetaJSON {
+		f.meta.defaults[k] = v
 	}
+	f.meta.byHash = make(map[string]map[string]interface{})
 	f.lazyFdFactory = func(name string, params Params) (fd *FetchDefinition, exist bool, err error) {
 		return nil, false, nil
 	}
 	return f
 }
 
+// SetOptionalFetchBudget limits the time for loading optional contents.
+// After the budget, starting from now, optional fetch jobs are canceled, so that
+// their includes render the alternative content. Required fetch jobs are not affected.
+// Setting the budget is optional, but if used, has to be done before adding Jobs by AddFetchJob.
+func (fetcher *ContentFetcher) SetOptionalFetchBudget(budget time.Duration) {
+	fetcher.optionalCtx, fetcher.cancelOptional = context.WithTimeout(fetcher.ctx, budget)
+}
+
 // SetFetchDefinitionFactory supplies a factory for lazy evaluated fetch jobs,
 // which will only be loaded if a fragment refrences them.
 // Seting the factory of optional, but if used, has to be done before adding Jobs by AddFetchJob.
@@ -76,31 +106,109 @@ func (fetcher *ContentFetcher) SetFetchDefinitionFactory(factory FetchDefinition
 
 // Wait blocks until all jobs are done,
 // either successful or with an error result and returns the content and errors.
-// Do we need to return the Results in a special order????
+// The results are returned in a predictable order, independent of the response times:
+// At first the jobs added by AddFetchJob, followed by their dependencies, level by level (breadth-first).
+// The dependencies of a content are ordered by the position of their uic-fetch elements,
+// followed by the lazy loaded includes, ordered by name.
+// If priorities are set, the results are sorted by priority, keeping this order for equal priorities.
 func (fetcher *ContentFetcher) WaitForResults() []*FetchResult {
 	fetcher.activeJobs.Wait()
+	if fetcher.cancelOptional != nil {
+		fetcher.cancelOptional()
+	}
 
 	fetcher.r.mutex.Lock()
 	defer fetcher.r.mutex.Unlock()
 
-	results := fetcher.r.results
+	return fetcher.orderResults(fetcher.r.results)
+}
 
-	// To keep initial order if no priority settings are given, do a check before for sorting.
-	if hasPrioritySetting(results) {
-		sort.Sort(FetchResults(results))
+// ScheduledResults returns the results of all jobs added so far, without waiting for them.
+// Only Def and Hash may be read from results of jobs, which are not done.
+func (fetcher *ContentFetcher) ScheduledResults() []*FetchResult {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+	results := make([]*FetchResult, len(fetcher.r.results))
+	copy(results, fetcher.r.results)
+	return results
+}
+
+// WaitForNewResults blocks until more than known fetch jobs are done, or until all jobs are done.
+// It returns the results of the done jobs in the order of their completion, a copy of the
+// meta JSON composed so far and complete=true, if there are no more outstanding jobs.
+func (fetcher *ContentFetcher) WaitForNewResults(known int) (finished []*FetchResult, metaJSON map[string]interface{}, complete bool) {
+	fetcher.r.mutex.Lock()
+	for len(fetcher.r.finished) <= known && len(fetcher.r.finished) < len(fetcher.r.results) {
+		fetcher.r.finishedCond.Wait()
 	}
+	finished = make([]*FetchResult, len(fetcher.r.finished))
+	copy(finished, fetcher.r.finished)
+	complete = len(fetcher.r.finished) == len(fetcher.r.results)
+	ordered := fetcher.orderResults(finished)
+	fetcher.r.mutex.Unlock()
 
-	return results
+	if complete && fetcher.cancelOptional != nil {
+		fetcher.cancelOptional()
+	}
+
+	return finished, fetcher.composeMetaJSON(ordered), complete
+}
+
+// orderResults returns a copy of the supplied results in the order of the dependency tree.
+// Jobs reachable by multiple contents are placed at their first position.
+// The method has to be called in a locked mutex block.
+func (fetcher *ContentFetcher) orderResults(results []*FetchResult) []*FetchResult {
+	byHash := make(map[string]*FetchResult, len(results))
+	for _, res := range results {
+		byHash[res.Hash] = res
+	}
+
+	ordered := make([]*FetchResult, 0, len(results))
+	visited := make(map[string]bool, len(results))
+	queue := append([]string{}, fetcher.r.rootHashes...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if visited[hash] {
+			continue
+		}
+		visited[hash] = true
+		if res, found := byHash[hash]; found {
+			ordered = append(ordered, res)
+		}
+		queue = append(queue, fetcher.r.dependencyHashes[hash]...)
+	}
+
+	// should not happen, but keep results which are not reachable from the roots
+	for _, res := range results {
+		if !visited[res.Hash] {
+			visited[res.Hash] = true
+			ordered = append(ordered, res)
+		}
+	}
+
+	// To keep initial order if no priority settings are given, do a check before for sorting.
+	if hasPrioritySetting(ordered) {
+		sort.Stable(FetchResults(ordered))
+	}
+	return ordered
 }
 
 //func (fetcher *ContentFetcher) AddFetchDefinitionFactory(name string, func(params map[string]string) *FetchDefinition) {
 
 // AddFetchJob adds one job to the fetcher and recursively adds the dependencies also.
 func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
+	fetcher.addFetchJob(d, "", false)
+}
+
+// addFetchJob adds a job as dependency of the job with the parentHash,
+// or as root job, if isDependency is false.
+func (fetcher *ContentFetcher) addFetchJob(d *FetchDefinition, parentHash string, isDependency bool) {
 	fetcher.r.mutex.Lock()
 	defer fetcher.r.mutex.Unlock()
 
 	hash := d.Hash()
+	fetcher.addEdge(parentHash, hash, isDependency)
 	if fetcher.isAlreadyScheduled(hash) {
 		return
 	}
@@ -108,10 +216,11 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 	fetcher.activeJobs.Add(1)
 	fetchResult := &FetchResult{Def: d, Hash: hash, Err: errors.New("not fetched")}
 	fetcher.r.results = append(fetcher.r.results, fetchResult)
-	fetcher.r.sheduledFetchDefinitionNames[d.Name] = d.Name
+	fetcher.r.sheduledFetchDefinitionNames[d.Name] = hash
 
 	go func() {
 		defer fetcher.activeJobs.Done()
+		defer fetcher.markFinished(fetchResult)
 
 		url, err := fetcher.expandTemplateVars(d.URL)
 		if err != nil {
@@ -126,12 +235,29 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 		// want to override the original URL with expanded values.
 		definitionCopy := *d
 		definitionCopy.URL = url
-		fetchResult.Content, fetchResult.Err = fetcher.Loader.Load(&definitionCopy)
+		ctx := fetcher.ctx
+		if !d.Required {
+			ctx = fetcher.optionalCtx
+		}
+		ctx, span := tracing.Default.Start(ctx, "ContentFetcher.AddFetchJob")
+		defer span.End()
+		span.SetAttribute("name", d.Name)
+		span.SetAttribute("url", url)
+		span.SetAttribute("required", d.Required)
+
+		fetchResult.Content, fetchResult.Err = fetcher.Loader.Load(ctx, &definitionCopy)
+		if fetchResult.Err != nil && d.FallbackURL != "" && ctx.Err() == nil {
+			fetchResult.Content, fetchResult.Err = fetcher.loadFallback(ctx, definitionCopy, fetchResult.Err)
+		}
 
 		if fetchResult.Err == nil {
-			fetcher.addMeta(fetchResult.Content.Meta())
-			fetcher.addDependentFetchJobs(fetchResult.Content)
+			fetcher.addMeta(hash, fetchResult.Content.Meta())
+			fetcher.addDependentFetchJobs(fetchResult.Content, hash)
 		} else {
+			span.SetError(fetchResult.Err)
+			if d.Required {
+				metrics.Default.RequiredFetchFailed(backendName(d.URL))
+			}
 			// 404 Error already become logged in logger.go
 			if fetchResult.Content == nil || fetchResult.Content.HttpStatusCode() != 404 {
 				logging.Logger.WithError(fetchResult.Err).
@@ -143,31 +269,98 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 	}()
 }
 
-func (fetcher *ContentFetcher) addDependentFetchJobs(content Content) {
-	for _, fetch := range content.RequiredContent() {
-		fetcher.AddFetchJob(fetch)
-	}
-	for dependencyName, params := range content.Dependencies() {
-		fetcher.r.mutex.Lock()
-		_, alreadySheduled := fetcher.r.sheduledFetchDefinitionNames[dependencyName]
-		fetcher.r.mutex.Unlock()
-		if !alreadySheduled {
-			lazyFd, existing, err := fetcher.lazyFdFactory(dependencyName, params)
-			if err != nil {
-				logging.Logger.WithError(err).
-					WithField("dependencyName", dependencyName).
-					WithField("params", params).
-					Errorf("failed optaining a fetch definition for dependency %v", dependencyName)
-			}
-			if err == nil && existing {
-				fetcher.AddFetchJob(lazyFd)
-			}
-			// error handling: In the case, the fd could not be loaded, we will do
-			// the error handling in the merging process.
+// loadFallback loads the FallbackURL of a fetch definition, after loading the URL failed.
+func (fetcher *ContentFetcher) loadFallback(ctx context.Context, d FetchDefinition, cause error) (Content, error) {
+	fallbackURL, err := fetcher.expandTemplateVars(d.FallbackURL)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Logger.WithError(cause).
+		WithField("fetchDefinition", &d).
+		Warnf("failed fetching %v, using fallback %v", d.URL, fallbackURL)
+
+	d.URL = fallbackURL
+	d.FallbackURL = ""
+	return fetcher.Loader.Load(ctx, &d)
+}
+
+// markFinished notifies the waiting WaitForNewResults calls about a done job.
+func (fetcher *ContentFetcher) markFinished(fetchResult *FetchResult) {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+	fetcher.r.finished = append(fetcher.r.finished, fetchResult)
+	fetcher.r.finishedCond.Broadcast()
+}
+
+// addEdge records the position of a job within the dependency tree.
+// The method has to be called in a locked mutex block.
+func (fetcher *ContentFetcher) addEdge(parentHash, hash string, isDependency bool) {
+	if isDependency {
+		fetcher.r.dependencyHashes[parentHash] = append(fetcher.r.dependencyHashes[parentHash], hash)
+	} else {
+		fetcher.r.rootHashes = append(fetcher.r.rootHashes, hash)
+	}
+}
+
+// addDependentFetchJobs adds the jobs for the uic-fetch and uic-include elements of the content
+// in the order of the document, so that the results are ordered by the dependency tree.
+func (fetcher *ContentFetcher) addDependentFetchJobs(content Content, parentHash string) {
+	for _, ref := range orderedDependencies(content) {
+		if ref.fd != nil {
+			fetcher.addFetchJob(ref.fd, parentHash, true)
+		} else {
+			fetcher.addDependencyJob(ref.name, ref.params, parentHash)
+		}
+	}
+}
+
+func (fetcher *ContentFetcher) addDependencyJob(dependencyName string, params Params, parentHash string) {
+	fetcher.r.mutex.Lock()
+	sheduledHash, alreadySheduled := fetcher.r.sheduledFetchDefinitionNames[dependencyName]
+	if alreadySheduled {
+		fetcher.addEdge(parentHash, sheduledHash, true)
+	}
+	fetcher.r.mutex.Unlock()
+	if !alreadySheduled {
+		lazyFd, existing, err := fetcher.lazyFdFactory(dependencyName, params)
+		if err != nil {
+			logging.Logger.WithError(err).
+				WithField("dependencyName", dependencyName).
+				WithField("params", params).
+				Errorf("failed optaining a fetch definition for dependency %v", dependencyName)
+		}
+		if err == nil && existing {
+			fetcher.addFetchJob(lazyFd, parentHash, true)
 		}
+		// error handling: In the case, the fd could not be loaded, we will do
+		// the error handling in the merging process.
 	}
 }
 
+// orderedDependencies returns the required content and the dependencies of a content in document order.
+// For contents, which are no MemoryContent, the required content is followed by the dependencies ordered by name.
+func orderedDependencies(content Content) []contentRef {
+	if c, isMemoryContent := content.(*MemoryContent); isMemoryContent {
+		return c.orderedDependencies()
+	}
+
+	refs := make([]contentRef, 0)
+	for _, fd := range content.RequiredContent() {
+		refs = append(refs, contentRef{fd: fd})
+	}
+	dependencies := content.Dependencies()
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		refs = append(refs, contentRef{name: name, params: dependencies[name]})
+	}
+	return refs
+}
+
 func (fetcher *ContentFetcher) Empty() bool {
 	fetcher.r.mutex.Lock()
 	defer fetcher.r.mutex.Unlock()
@@ -185,22 +378,46 @@ func (fetcher *ContentFetcher) isAlreadyScheduled(fetchDefinitionHash string) bo
 	return false
 }
 
+// MetaJSON returns the composed meta JSON object.
+// It contains the default meta JSON, overwritten by the meta JSON of the loaded contents
+// in the order of WaitForResults. So on collisions, the value of the last content wins.
 func (fetcher *ContentFetcher) MetaJSON() map[string]interface{} {
+	fetcher.r.mutex.Lock()
+	ordered := fetcher.orderResults(fetcher.r.finished)
+	fetcher.r.mutex.Unlock()
+
+	return fetcher.composeMetaJSON(ordered)
+}
+
+// composeMetaJSON merges the default meta JSON and the meta JSON of the supplied results in their order.
+func (fetcher *ContentFetcher) composeMetaJSON(results []*FetchResult) map[string]interface{} {
 	fetcher.meta.mutex.Lock()
 	defer fetcher.meta.mutex.Unlock()
-	return fetcher.meta.json
+
+	metaJSON := make(map[string]interface{}, len(fetcher.meta.defaults))
+	for k, v := range fetcher.meta.defaults {
+		metaJSON[k] = v
+	}
+	for _, res := range results {
+		for k, v := range fetcher.meta.byHash[res.Hash] {
+			metaJSON[k] = v
+		}
+	}
+	return metaJSON
 }
 
+// expandTemplateVars expands the url template with the meta JSON of the contents loaded so far,
+// which is merged in the order of the dependency tree, like the MetaJSON.
 func (fetcher *ContentFetcher) expandTemplateVars(template string) (string, error) {
-	fetcher.meta.mutex.Lock()
-	defer fetcher.meta.mutex.Unlock()
-	return expandTemplateVars(template, fetcher.meta.json)
+	fetcher.r.mutex.Lock()
+	ordered := fetcher.orderResults(fetcher.r.results)
+	fetcher.r.mutex.Unlock()
+
+	return expandTemplateVars(template, fetcher.composeMetaJSON(ordered))
 }
 
-func (fetcher *ContentFetcher) addMeta(data map[string]interface{}) {
+func (fetcher *ContentFetcher) addMeta(hash string, data map[string]interface{}) {
 	fetcher.meta.mutex.Lock()
 	defer fetcher.meta.mutex.Unlock()
-	for k, v := range data {
-		fetcher.meta.json[k] = v
-	}
+	fetcher.meta.byHash[hash] = data
 }
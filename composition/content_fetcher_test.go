@@ -1,9 +1,13 @@
 package composition
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -62,7 +66,7 @@ func Test_ContentFetcher_LazyDependencies(t *testing.T) {
 	parent := NewFetchDefinition("/parent")
 	content := NewMockContent(ctrl)
 	loader.EXPECT().
-		Load(parent).
+		Load(gomock.Any(), parent).
 		Return(content, nil)
 
 	content.EXPECT().
@@ -94,6 +98,201 @@ func Test_ContentFetcher_LazyDependencies(t *testing.T) {
 	a.Equal("/child", results[1].Def.URL)
 }
 
+func Test_ContentFetcher_DeterministicOrderOfDependencies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	loader := NewMockContentLoader(ctrl)
+	xFd := getFetchDefinitionMock(ctrl, loader, "/x", nil, time.Millisecond, map[string]interface{}{"key": "x"})
+	barFd := getFetchDefinitionMock(ctrl, loader, "/bar", nil, time.Millisecond*3, map[string]interface{}{"key": "bar"})
+
+	// foo is done after bazz, but is the first job, so its dependency /x comes first
+	fooFd := getFetchDefinitionMock(ctrl, loader, "/foo", []*FetchDefinition{xFd}, time.Millisecond*10, map[string]interface{}{})
+	bazzFd := getFetchDefinitionMock(ctrl, loader, "/bazz", []*FetchDefinition{barFd, xFd}, time.Millisecond, map[string]interface{}{})
+
+	fetcher := NewContentFetcher(map[string]interface{}{"key": "default"})
+	fetcher.Loader = loader
+
+	fetcher.AddFetchJob(fooFd)
+	fetcher.AddFetchJob(bazzFd)
+
+	results := fetcher.WaitForResults()
+
+	a.Equal(4, len(results))
+	a.Equal("/foo", results[0].Def.URL)
+	a.Equal("/bazz", results[1].Def.URL)
+	a.Equal("/x", results[2].Def.URL)
+	a.Equal("/bar", results[3].Def.URL)
+
+	// the meta JSON of the last content wins
+	a.Equal("bar", fetcher.MetaJSON()["key"])
+}
+
+func Test_ContentFetcher_UrlTemplatesExpandedInDependencyOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a dependency with an url template, which is expanded to /d/2
+	loader := NewMockContentLoader(ctrl)
+	expandedFd := getFetchDefinitionMock(ctrl, loader, "/d/2", nil, 0, nil)
+	templateFd := *expandedFd
+	templateFd.URL = "/d/§[key]§"
+
+	// and the first job, which is done after the second
+	firstFd := getFetchDefinitionMock(ctrl, loader, "/first", []*FetchDefinition{&templateFd}, time.Millisecond*10, map[string]interface{}{"key": "1"})
+	secondFd := getFetchDefinitionMock(ctrl, loader, "/second", nil, time.Millisecond, map[string]interface{}{"key": "2"})
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+
+	// when
+	fetcher.AddFetchJob(firstFd)
+	fetcher.AddFetchJob(secondFd)
+	results := fetcher.WaitForResults()
+
+	// then the url is expanded with the meta JSON of the second job, like the MetaJSON, independent of the completion order
+	a.Equal(3, len(results))
+	a.NoError(results[2].Err)
+	a.Equal("2", fetcher.MetaJSON()["key"])
+}
+
+func Test_ContentFetcher_LazyDependenciesOrderedByName(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	loader := NewMockContentLoader(ctrl)
+
+	parent := NewFetchDefinition("/parent")
+	content := NewMockContent(ctrl)
+	loader.EXPECT().
+		Load(gomock.Any(), parent).
+		Return(content, nil)
+
+	content.EXPECT().
+		RequiredContent().
+		Return([]*FetchDefinition{})
+	content.EXPECT().
+		Meta().
+		Return(nil)
+	content.EXPECT().
+		Dependencies().
+		Return(map[string]Params{"/b": Params{}, "/a": Params{}})
+
+	// /b is done much faster than /a
+	aFd := getFetchDefinitionMock(ctrl, loader, "/a", nil, time.Millisecond*5, nil)
+	bFd := getFetchDefinitionMock(ctrl, loader, "/b", nil, 0, nil)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+	fetcher.SetFetchDefinitionFactory(func(name string, params Params) (fd *FetchDefinition, exist bool, err error) {
+		if name == "/a" {
+			return aFd, true, nil
+		}
+		return bFd, true, nil
+	})
+
+	fetcher.AddFetchJob(parent)
+	results := fetcher.WaitForResults()
+
+	a.Equal(3, len(results))
+	a.Equal("/parent", results[0].Def.URL)
+	a.Equal("/a", results[1].Def.URL)
+	a.Equal("/b", results[2].Def.URL)
+}
+
+func Test_ContentFetcher_DependenciesInDocumentOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given a parent, whose includes and fetches are not in the order of their names
+	parent := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(parent, strings.NewReader(`<html><body>
+      <uic-include src="/b#content"/>
+      <uic-fetch src="/c"/>
+      <uic-include src="/a#content"/>
+    </body></html>`))
+	a.NoError(err)
+
+	loader := NewMockContentLoader(ctrl)
+	parentFd := NewFetchDefinition("/parent")
+	loader.EXPECT().Load(gomock.Any(), parentFd).Return(parent, nil)
+	loader.EXPECT().Load(gomock.Any(), fetchDefinitionWithURL("/c")).Return(NewMemoryContent(), nil)
+
+	// /b is done much slower than /a
+	aFd := getFetchDefinitionMock(ctrl, loader, "/a", nil, 0, nil)
+	bFd := getFetchDefinitionMock(ctrl, loader, "/b", nil, time.Millisecond*5, nil)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+	fetcher.SetFetchDefinitionFactory(func(name string, params Params) (fd *FetchDefinition, exist bool, err error) {
+		if name == "/a" {
+			return aFd, true, nil
+		}
+		return bFd, true, nil
+	})
+
+	// when
+	fetcher.AddFetchJob(parentFd)
+	results := fetcher.WaitForResults()
+
+	// then the results are in the order of the document
+	a.Equal(4, len(results))
+	a.Equal("/parent", results[0].Def.URL)
+	a.Equal("/b", results[1].Def.URL)
+	a.Equal("/c", results[2].Def.URL)
+	a.Equal("/a", results[3].Def.URL)
+}
+
+func Test_ContentFetcher_OptionalFetchBudget(t *testing.T) {
+	a := assert.New(t)
+
+	requiredServer := testServer("<html><body>required</body></html>", time.Millisecond*50)
+	defer requiredServer.Close()
+	optionalServer := testServer("<html><body>optional</body></html>", time.Second)
+	defer optionalServer.Close()
+
+	fetcher := NewContentFetcherWithContext(context.Background(), nil)
+	fetcher.SetOptionalFetchBudget(time.Millisecond * 20)
+
+	optionalFd := NewFetchDefinition(optionalServer.URL)
+	optionalFd.Required = false
+	fetcher.AddFetchJob(NewFetchDefinition(requiredServer.URL))
+	fetcher.AddFetchJob(optionalFd)
+
+	start := time.Now()
+	results := fetcher.WaitForResults()
+	a.True(time.Since(start) < time.Millisecond*500)
+
+	a.Equal(2, len(results))
+	a.NoError(results[0].Err)
+	a.Error(results[1].Err)
+}
+
+func Test_ContentFetcher_CanceledContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	loader := NewMockContentLoader(ctrl)
+	loader.EXPECT().Load(ctx, gomock.Any()).Return(nil, context.Canceled)
+
+	fetcher := NewContentFetcherWithContext(ctx, nil)
+	fetcher.Loader = loader
+	cancel()
+
+	fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+	results := fetcher.WaitForResults()
+
+	a.Equal(1, len(results))
+	a.Equal(context.Canceled, results[0].Err)
+}
+
 func getFetchDefinitionMock(ctrl *gomock.Controller, loaderMock *MockContentLoader, url string, requiredContent []*FetchDefinition, loaderBlocking time.Duration, metaJSON map[string]interface{}) *FetchDefinition {
 	fd := NewFetchDefinition(url)
 	fd.Timeout = time.Second * 42
@@ -112,9 +311,9 @@ func getFetchDefinitionMock(ctrl *gomock.Controller, loaderMock *MockContentLoad
 		Return(map[string]Params{})
 
 	loaderMock.EXPECT().
-		Load(fd).
+		Load(gomock.Any(), fd).
 		Do(
-			func(fetchDefinition *FetchDefinition) {
+			func(ctx context.Context, fetchDefinition *FetchDefinition) {
 				time.Sleep(loaderBlocking)
 			}).
 		Return(content, nil)
@@ -169,3 +368,109 @@ func Test_ContentFetcher_PriorityOrderAfterFetchCompletion(t *testing.T) {
 	a.Equal(1024, results[2].Def.Priority)
 
 }
+
+func Test_ContentFetcher_WaitForNewResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	loader := NewMockContentLoader(ctrl)
+	barFd := getFetchDefinitionMock(ctrl, loader, "/bar", nil, time.Millisecond*2, map[string]interface{}{"foo": "bar"})
+	fooFd := getFetchDefinitionMock(ctrl, loader, "/foo", []*FetchDefinition{barFd}, time.Millisecond*2, map[string]interface{}{"bli": "bla"})
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+
+	fetcher.AddFetchJob(fooFd)
+
+	scheduled := fetcher.ScheduledResults()
+	a.Equal(1, len(scheduled))
+	a.Equal("/foo", scheduled[0].Def.URL)
+
+	finished, metaJSON, complete := fetcher.WaitForNewResults(0)
+	a.Equal(1, len(finished))
+	a.Equal("/foo", finished[0].Def.URL)
+	a.Equal("bla", metaJSON["bli"])
+	a.False(complete)
+
+	finished, metaJSON, complete = fetcher.WaitForNewResults(len(finished))
+	a.Equal(2, len(finished))
+	a.Equal("/bar", finished[1].Def.URL)
+	a.Equal("bar", metaJSON["foo"])
+	a.True(complete)
+
+	finished, _, complete = fetcher.WaitForNewResults(len(finished))
+	a.Equal(2, len(finished))
+	a.True(complete)
+}
+
+func Test_ContentFetcher_FallbackURL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given
+	loader := NewMockContentLoader(ctrl)
+	content := NewMockContent(ctrl)
+	content.EXPECT().RequiredContent().Return(nil)
+	content.EXPECT().Dependencies().Return(nil)
+	content.EXPECT().Meta().Return(nil)
+
+	fd := NewFetchDefinition("/primary").WithFallbackURL("/fallback")
+	loader.EXPECT().Load(gomock.Any(), fetchDefinitionWithURL("/primary")).
+		Return(nil, errors.New("some error"))
+	loader.EXPECT().Load(gomock.Any(), fetchDefinitionWithURL("/fallback")).
+		Return(content, nil)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+
+	// when
+	fetcher.AddFetchJob(fd)
+	results := fetcher.WaitForResults()
+
+	// then
+	a.Equal(1, len(results))
+	a.NoError(results[0].Err)
+	a.Equal(content, results[0].Content)
+	a.Equal("/primary", results[0].Def.URL)
+}
+
+func Test_ContentFetcher_FallbackURLFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given
+	loader := NewMockContentLoader(ctrl)
+	fd := NewFetchDefinition("/primary").WithFallbackURL("/fallback")
+	loader.EXPECT().Load(gomock.Any(), fetchDefinitionWithURL("/primary")).
+		Return(nil, errors.New("primary error"))
+	loader.EXPECT().Load(gomock.Any(), fetchDefinitionWithURL("/fallback")).
+		Return(nil, errors.New("fallback error"))
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+
+	// when
+	fetcher.AddFetchJob(fd)
+	results := fetcher.WaitForResults()
+
+	// then
+	a.EqualError(results[0].Err, "fallback error")
+}
+
+type fetchDefinitionURLMatcher string
+
+func fetchDefinitionWithURL(url string) gomock.Matcher {
+	return fetchDefinitionURLMatcher(url)
+}
+
+func (m fetchDefinitionURLMatcher) Matches(x interface{}) bool {
+	fd, ok := x.(*FetchDefinition)
+	return ok && fd.URL == string(m)
+}
+
+func (m fetchDefinitionURLMatcher) String() string {
+	return fmt.Sprintf("is fetch definition with url %v", string(m))
+}
@@ -3,8 +3,10 @@ package composition
 import (
 	"bytes"
 	"errors"
+	"github.com/tarent/lib-compose/metrics"
 	"io"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,13 +19,15 @@ const (
 // out of multiple Content pages.
 type ContentMerge struct {
 	MetaJSON  map[string]interface{}
-	Head      []Fragment
+	HtmlAttrs *HtmlAttributes
+	Head      *HeadModel
 	BodyAttrs []Fragment
 
 	// Aggregator for the Body Fragments of the results.
 	// Each fragment is insertes twice with full name and local name,
 	// The full name only ends with a FragmentSeparater ('#'), if the local name is not empty
 	// and the local name is always prefixed with FragmentSeparater ('#').
+	// On name collisions, the fragment of the content added last wins.
 	Body map[string]Fragment
 
 	// Aggregator for the Tail Fragments of the results.
@@ -33,13 +37,21 @@ type ContentMerge struct {
 	// merge priorities for the content objects
 	// no entry means priority == 0
 	priorities map[Content]int
+
+	// the nonce of the Content-Security-Policy, which is added to script and style elements
+	nonce string
+
+	// head fragments of contents, which were added after the head was written
+	lateHeads   []Fragment
+	headWritten bool
 }
 
 // NewContentMerge creates a new buffered ContentMerge
 func NewContentMerge(metaJSON map[string]interface{}) *ContentMerge {
 	cntx := &ContentMerge{
 		MetaJSON:   metaJSON,
-		Head:       make([]Fragment, 0, 0),
+		HtmlAttrs:  NewHtmlAttributes(),
+		Head:       NewHeadModel(),
 		BodyAttrs:  make([]Fragment, 0, 0),
 		Body:       make(map[string]Fragment),
 		Tail:       make([]Fragment, 0, 0),
@@ -49,15 +61,43 @@ func NewContentMerge(metaJSON map[string]interface{}) *ContentMerge {
 	return cntx
 }
 
+// SetNonce sets the nonce, which is added to all script and style elements of the merged html.
+func (cntx *ContentMerge) SetNonce(nonce string) {
+	cntx.nonce = nonce
+}
+
 func (cntx *ContentMerge) GetHtml() ([]byte, error) {
-	if len(cntx.priorities) > 0 {
-		cntx.processMetaPriorityParsing()
-	}
 	w := bytes.NewBuffer(make([]byte, 0, DefaultBufferSize))
+	if err := cntx.WriteHtml(w, nil); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// WriteHtml writes the combined html document to w.
+// If awaitContent is not nil, it is called to wait for further contents,
+// if a body fragment is missing and before the tail is written.
+// The head fragments of contents, which were added after the head was written,
+// are written at the end of the body, before the tail fragments.
+// The duration is recorded as merge metric, which includes the waiting for contents.
+func (cntx *ContentMerge) WriteHtml(w io.Writer, awaitContent func() bool) error {
+	start := time.Now()
+	defer func() {
+		metrics.Default.Merge(time.Since(start))
+	}()
+
+	if cntx.nonce != "" {
+		w = newNonceWriter(w, cntx.nonce)
+	}
+	// all fragments are written with the same html context, so that the values are escaped for their position in the page
+	w = newHtmlContextWriter(w)
 
 	var executeFragment func(fragmentName string) error
 	executeFragment = func(fragmentName string) error {
 		f, exist := cntx.GetBodyFragmentByName(fragmentName)
+		for !exist && awaitContent != nil && awaitContent() {
+			f, exist = cntx.GetBodyFragmentByName(fragmentName)
+		}
 		if !exist {
 			missingFragmentString := generateMissingFragmentString(cntx.Body, fragmentName)
 			return errors.New(missingFragmentString)
@@ -65,20 +105,27 @@ func (cntx *ContentMerge) GetHtml() ([]byte, error) {
 		return f.Execute(w, cntx.MetaJSON, executeFragment)
 	}
 
-	io.WriteString(w, "<!DOCTYPE html>\n<html>\n  <head>\n    ")
+	io.WriteString(w, "<!DOCTYPE html>\n<html")
+	if err := cntx.HtmlAttrs.Write(w, cntx.MetaJSON, executeFragment); err != nil {
+		return err
+	}
+	io.WriteString(w, ">\n  <head>\n    ")
 
-	for _, f := range cntx.Head {
-		if err := f.Execute(w, cntx.MetaJSON, executeFragment); err != nil {
-			return nil, err
-		}
+	cntx.headWritten = true
+	if err := cntx.Head.Write(w, cntx.MetaJSON, executeFragment); err != nil {
+		return err
 	}
+	resourceMap := cntx.Head.resourceMap()
+	cntx.removeDuplicateTailResources(resourceMap)
+	processedTails := len(cntx.Tail)
+
 	io.WriteString(w, "\n  </head>\n  <body")
 
 	for _, f := range cntx.BodyAttrs {
 		io.WriteString(w, " ")
 
 		if err := f.Execute(w, cntx.MetaJSON, executeFragment); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -90,18 +137,33 @@ func (cntx *ContentMerge) GetHtml() ([]byte, error) {
 	}
 
 	if err := executeFragment(startFragmentName); err != nil {
-		return nil, err
+		return err
+	}
+
+	if awaitContent != nil {
+		for awaitContent() {
+		}
+		// of the heads of contents, which were added later, only the resources, which were not written yet, and other markup are written
+		for _, f := range cntx.lateHeads {
+			f = lateHeadFragment(f, resourceMap)
+			if err := f.Execute(w, cntx.MetaJSON, executeFragment); err != nil {
+				return err
+			}
+		}
+		for i := processedTails; i < len(cntx.Tail); i++ {
+			cntx.Tail[i] = removeDuplicateResourcesOf(cntx.Tail[i], resourceMap)
+		}
 	}
 
 	for _, f := range cntx.Tail {
 		if err := f.Execute(w, cntx.MetaJSON, executeFragment); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	io.WriteString(w, "\n  </body>\n</html>\n")
 
-	return w.Bytes(), nil
+	return nil
 }
 
 // GetBodyFragmentByName returns a fragment by ists name.
@@ -125,7 +187,8 @@ func (cntx *ContentMerge) GetBodyFragmentByName(name string) (Fragment, bool) {
 }
 
 func (cntx *ContentMerge) AddContent(c Content, priority int) {
-	cntx.addHead(c.Head())
+	cntx.addHtmlAttributes(c.HtmlAttributes(), priority, c.Name() == LayoutFragmentName)
+	cntx.addHead(c.Head(), priority)
 	cntx.addBodyAttributes(c.BodyAttributes())
 	cntx.addBody(c)
 	cntx.addTail(c.Tail())
@@ -134,12 +197,23 @@ func (cntx *ContentMerge) AddContent(c Content, priority int) {
 	}
 }
 
-func (cntx *ContentMerge) addHead(f Fragment) {
+func (cntx *ContentMerge) addHtmlAttributes(f Fragment, priority int, isLayout bool) {
 	if f != nil {
-		cntx.Head = append(cntx.Head, f)
+		cntx.HtmlAttrs.Add(f, priority, isLayout)
 	}
 }
 
+func (cntx *ContentMerge) addHead(f Fragment, priority int) {
+	if f == nil {
+		return
+	}
+	if cntx.headWritten {
+		cntx.lateHeads = append(cntx.lateHeads, f)
+		return
+	}
+	cntx.Head.Add(f, priority)
+}
+
 func (cntx *ContentMerge) addBodyAttributes(f Fragment) {
 	if f != nil {
 		cntx.BodyAttrs = append(cntx.BodyAttrs, f)
@@ -180,16 +254,30 @@ func generateMissingFragmentString(body map[string]Fragment, fragmentName string
 	return text
 }
 
-// Processes all heads to remove duplicate meta and title tags, respecting the priority of head fragments
-func (cntx *ContentMerge) processMetaPriorityParsing() {
-	headPropertyMap := make(map[string]string)
-
-	for i := len(cntx.Head) - 1; i >= 0; i-- {
-		var currentHead interface{} = cntx.Head[i]
-		if currentHead != nil {
-			currentStringFragment := currentHead.(StringFragment)
-			ParseHeadFragment(&currentStringFragment, headPropertyMap)
-			cntx.Head[i] = currentStringFragment
+// removeDuplicateTailResources removes the resources from the tail fragments, which are contained in the head
+// or in a tail fragment with higher priority. Without priorities, the first one is kept,
+// so that the resources are loaded in the order of the contents.
+func (cntx *ContentMerge) removeDuplicateTailResources(resourceMap map[string]string) {
+	if len(cntx.priorities) > 0 {
+		for i := len(cntx.Tail) - 1; i >= 0; i-- {
+			cntx.Tail[i] = removeDuplicateResourcesOf(cntx.Tail[i], resourceMap)
 		}
+		return
+	}
+	for i := range cntx.Tail {
+		cntx.Tail[i] = removeDuplicateResourcesOf(cntx.Tail[i], resourceMap)
+	}
+}
+
+// removeDuplicateResourcesOf returns the fragment without the resources, which are contained in the resourceMap.
+// Only a StringFragment can be processed, other fragments are returned unchanged.
+func removeDuplicateResourcesOf(f Fragment, resourceMap map[string]string) Fragment {
+	sf, ok := f.(StringFragment)
+	if !ok {
+		return f
+	}
+	if err := removeDuplicateResources(&sf, resourceMap); err != nil {
+		return f
 	}
+	return sf
 }
@@ -1,8 +1,11 @@
 package composition
 
 import (
+	"bytes"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/metrics"
+	"strings"
 	"testing"
 )
 
@@ -68,6 +71,136 @@ func Test_ContentMerge_PositiveCase(t *testing.T) {
 	a.Equal(expected, string(html))
 }
 
+func Test_ContentMerge_AlternativeIncludeContent(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html><body>
+      <uic-include src="missing#content"><p>alternative</p></uic-include>
+    </body></html>`))
+	a.NoError(err)
+	c.name = LayoutFragmentName
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(c, 0)
+
+	html, err := cm.GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), "<p>alternative</p>")
+	a.NotContains(string(html), "§[")
+}
+
+func Test_ContentMerge_AlternativeIncludeContentIsExecuted(t *testing.T) {
+	a := assert.New(t)
+
+	// given an alternative with a variable, a condition and a loop
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html><body>
+      <uic-include src="missing#content">
+        <h1>§[ title ]§</h1>
+        <p uic-if="loggedIn">welcome</p>
+        <p uic-if="!loggedIn">login</p>
+        <ul><uic-loop items="items"><li>§[ item ]§</li></uic-loop></ul>
+      </uic-include>
+    </body></html>`))
+	a.NoError(err)
+	c.name = LayoutFragmentName
+
+	// when the included fragment is missing
+	cm := NewContentMerge(map[string]interface{}{
+		"title":    "<b>Title</b>",
+		"loggedIn": true,
+		"items":    []interface{}{"a", "b"},
+	})
+	cm.AddContent(c, 0)
+	html, err := cm.GetHtml()
+
+	// then the alternative is executed with the escaping of its context
+	a.NoError(err)
+	a.Contains(string(html), "<h1>&lt;b&gt;Title&lt;/b&gt;</h1>")
+	a.Contains(string(html), "<p>welcome</p>")
+	a.NotContains(string(html), "login")
+	a.Contains(string(html), "<ul><li>a</li><li>b</li></ul>")
+	a.NotContains(string(html), "§[")
+	a.NotContains(string(html), "uic-")
+}
+
+func Test_ContentMerge_RemovesDuplicateResources(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		head: StringFragment(`<link rel="stylesheet" href="/shared/base.css"><script src="jquery.js"></script>`),
+		body: map[string]Fragment{"": StringFragment(`§[> fragment]§`)},
+		tail: StringFragment(`<script src="layout.js"></script>`),
+	}, 0)
+	cm.AddContent(&MemoryContent{
+		name: "fragment",
+		head: StringFragment(`<link rel="stylesheet" href="/shared/./base.css"><script src="jquery.js"></script><style>p {}</style>`),
+		body: map[string]Fragment{"": StringFragment(`<p>fragment</p>`)},
+		tail: StringFragment(`<script src="jquery.js"></script><script src="layout.js"></script><script src="fragment.js"></script>`),
+	}, 0)
+
+	html, err := cm.GetHtml()
+	a.NoError(err)
+	a.Equal(`<!DOCTYPE html>
+<html>
+  <head>
+    <link rel="stylesheet" href="/shared/base.css">
+    <script src="jquery.js"></script>
+    <style>p {}</style>
+  </head>
+  <body>
+    <p>fragment</p><script src="layout.js"></script><script src="fragment.js"></script>
+  </body>
+</html>
+`, string(html))
+}
+
+func Test_ContentMerge_RemovesDuplicateResourcesByPriority(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		head: StringFragment(`<link rel="stylesheet" href="base.css" id="low">`),
+		body: map[string]Fragment{"": StringFragment(`layout`)},
+		tail: StringFragment(`<script src="app.js" id="low"></script>`),
+	}, 0)
+	cm.AddContent(&MemoryContent{
+		name: "fragment",
+		head: StringFragment(`<link rel="stylesheet" href="base.css" id="high">`),
+		tail: StringFragment(`<script src="app.js" id="high"></script>`),
+	}, MAX_PRIORITY)
+
+	html, err := cm.GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), `<link rel="stylesheet" href="base.css" id="high">`)
+	a.NotContains(string(html), `<link rel="stylesheet" href="base.css" id="low">`)
+	a.Contains(string(html), `<script src="app.js" id="high"></script>`)
+	a.NotContains(string(html), `<script src="app.js" id="low"></script>`)
+}
+
+func Test_ContentMerge_HtmlAttributes(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name:           LayoutFragmentName,
+		htmlAttributes: StringFragment(`lang="de" class="no-js"`),
+		body:           map[string]Fragment{"": StringFragment(`layout`)},
+	}, 0)
+	cm.AddContent(&MemoryContent{
+		name:           "fragment",
+		htmlAttributes: StringFragment(`lang="en" class="fragment"`),
+	}, MAX_PRIORITY)
+
+	html, err := cm.GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), "<!DOCTYPE html>\n<html lang=\"de\" class=\"no-js fragment\">\n  <head>")
+}
+
 func Test_ContentMerge_BodyCompositionWithExplicitNames(t *testing.T) {
 	a := assert.New(t)
 
@@ -195,3 +328,130 @@ func (buff closedWriterMock) Write(b []byte) (int, error) {
 func asFetchResult(c Content) *FetchResult {
 	return &FetchResult{Content: c, Def: &FetchDefinition{URL: c.Name()}}
 }
+
+func Test_ContentMerge_WriteHtmlAwaitsMissingFragments(t *testing.T) {
+	a := assert.New(t)
+
+	expected := `<!DOCTYPE html>
+<html>
+  <head>
+    <page1-head/>
+  </head>
+  <body>
+    <page1-body-main>
+      <page2-body/>
+    </page1-body-main>
+<page2-head/>
+    <page1-tail/>
+  </body>
+</html>
+`
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		head: StringFragment("<page1-head/>"),
+		tail: StringFragment("\n    <page1-tail/>"),
+		body: map[string]Fragment{"": StringFragment(
+			`<page1-body-main>
+      §[> page2]§
+    </page1-body-main>
+`)},
+	}, 0)
+
+	calls := 0
+	awaitContent := func() bool {
+		calls++
+		if calls > 1 {
+			return false
+		}
+		cm.AddContent(&MemoryContent{
+			name: "page2",
+			head: StringFragment("<page2-head/>"),
+			body: map[string]Fragment{"": StringFragment("<page2-body/>")},
+		}, 0)
+		return true
+	}
+
+	buff := bytes.NewBuffer(nil)
+	err := cm.WriteHtml(buff, awaitContent)
+	a.NoError(err)
+	a.Equal(expected, buff.String())
+	a.Equal(2, calls)
+}
+
+func Test_ContentMerge_LateHeadWritesOnlyResourcesAndMarkup(t *testing.T) {
+	a := assert.New(t)
+
+	// given a layout, which includes a content, which is added after the head was written
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		head: StringFragment(`<title>Layout</title><link rel="stylesheet" href="/a.css">`),
+		body: map[string]Fragment{"": StringFragment(`§[> late]§`)},
+	}, 0)
+
+	added := false
+	awaitContent := func() bool {
+		if added {
+			return false
+		}
+		added = true
+		cm.AddContent(&MemoryContent{
+			name: "late",
+			head: StringFragment(`<title>Late</title><base href="/late/"><meta name="description" content="late">` +
+				`<link rel="stylesheet" href="/a.css"><link rel="stylesheet" href="/b.css"><script>late()</script>`),
+			body: map[string]Fragment{"": StringFragment("<late-body/>")},
+		}, 0)
+		return true
+	}
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := cm.WriteHtml(buff, awaitContent)
+
+	// then only the new resources and other markup are written into the body
+	a.NoError(err)
+	a.Contains(buff.String(), "<late-body/><link rel=\"stylesheet\" href=\"/b.css\">\n    <script>late()</script>")
+	a.NotContains(buff.String(), "Late")
+	a.NotContains(buff.String(), "<base")
+	a.NotContains(buff.String(), "<meta")
+	a.Equal(1, strings.Count(buff.String(), "/a.css"))
+}
+
+func Test_ContentMerge_WriteHtmlMissingFragmentAfterAwait(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		body: map[string]Fragment{"": StringFragment(`§[> page2]§`)},
+	}, 0)
+
+	err := cm.WriteHtml(bytes.NewBuffer(nil), func() bool { return false })
+	a.Error(err)
+}
+
+func Test_ContentMerge_WriteHtmlRecordsMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recorder for the metrics
+	recorder := metrics.NewPrometheusRecorder()
+	metrics.Default = recorder
+	defer func() {
+		metrics.Default = metrics.NopRecorder{}
+	}()
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		body: map[string]Fragment{"": StringFragment("<p>streamed</p>")},
+	}, 0)
+
+	// when the page is streamed
+	err := cm.WriteHtml(bytes.NewBuffer(nil), func() bool { return false })
+
+	// then the merge is recorded
+	a.NoError(err)
+	a.Contains(string(recorder.Text()), "lib_compose_merge_duration_seconds_count 1")
+}
@@ -0,0 +1,104 @@
+package composition
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// DefaultContentParsers are copied by the loaders, created by NewHttpContentLoader and NewFileContentLoader.
+// Register parsers here, to use them for all fetches.
+var DefaultContentParsers = NewContentParsers().
+	Register("text/html", &HtmlContentParser{}).
+	Register(ContentTypeUicJson, &JsonContentParser{})
+
+type parserRegistration struct {
+	contentType string
+	parser      ContentParser
+}
+
+// ContentParsers selects the ContentParser for the content type of a response.
+type ContentParsers struct {
+	registrations []parserRegistration
+	mutex         sync.RWMutex
+}
+
+func NewContentParsers() *ContentParsers {
+	return &ContentParsers{}
+}
+
+// Register sets the parser for a content type, e.g. "text/html".
+// The content type matches all types starting with it, so "application/" matches all application types.
+// An existing registration for the same type is replaced, a nil parser removes it.
+func (parsers *ContentParsers) Register(contentType string, parser ContentParser) *ContentParsers {
+	parsers.mutex.Lock()
+	defer parsers.mutex.Unlock()
+
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for i, r := range parsers.registrations {
+		if r.contentType == contentType {
+			parsers.registrations = append(parsers.registrations[:i], parsers.registrations[i+1:]...)
+			break
+		}
+	}
+	if parser != nil {
+		parsers.registrations = append(parsers.registrations, parserRegistration{contentType, parser})
+	}
+	return parsers
+}
+
+// Lookup returns the parser for the content type of a response. The parameters of the content type,
+// like the charset, are ignored. If several registered types match, the longest one is taken.
+func (parsers *ContentParsers) Lookup(contentType string) (ContentParser, bool) {
+	if parsers == nil {
+		return nil, false
+	}
+	parsers.mutex.RLock()
+	defer parsers.mutex.RUnlock()
+
+	mediaType := normalizeMediaType(contentType)
+	if mediaType == "" {
+		return nil, false
+	}
+
+	var found *parserRegistration
+	for i, r := range parsers.registrations {
+		if strings.HasPrefix(mediaType, r.contentType) && (found == nil || len(r.contentType) > len(found.contentType)) {
+			found = &parsers.registrations[i]
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	return found.parser, true
+}
+
+// ContentTypes returns the registered content types in order of registration.
+func (parsers *ContentParsers) ContentTypes() []string {
+	parsers.mutex.RLock()
+	defer parsers.mutex.RUnlock()
+
+	types := make([]string, 0, len(parsers.registrations))
+	for _, r := range parsers.registrations {
+		types = append(types, r.contentType)
+	}
+	return types
+}
+
+// Copy returns an independent copy, so that registrations on the copy do not affect the original.
+func (parsers *ContentParsers) Copy() *ContentParsers {
+	parsers.mutex.RLock()
+	defer parsers.mutex.RUnlock()
+
+	c := NewContentParsers()
+	c.registrations = append(c.registrations, parsers.registrations...)
+	return c
+}
+
+func normalizeMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.Split(contentType, ";")[0]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
@@ -0,0 +1,74 @@
+package composition
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+type namedParser string
+
+func (p namedParser) Parse(c *MemoryContent, in io.Reader) error {
+	return nil
+}
+
+func Test_ContentParsers_Lookup(t *testing.T) {
+	a := assert.New(t)
+
+	parsers := NewContentParsers().
+		Register("application/", namedParser("application")).
+		Register("text/html", namedParser("html")).
+		Register("application/vnd.uic+json", namedParser("uic"))
+
+	tests := []struct {
+		contentType string
+		parser      ContentParser
+	}{
+		{"text/html", namedParser("html")},
+		{"text/html; charset=utf-8", namedParser("html")},
+		{"Text/HTML;charset=UTF-8", namedParser("html")},
+		{"application/vnd.uic+json", namedParser("uic")},
+		{"application/vnd.uic+json; charset=utf-8", namedParser("uic")},
+		{"application/json", namedParser("application")},
+		{"text/plain", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		// the longest match wins on every lookup, independent from any map ordering
+		for i := 0; i < 10; i++ {
+			parser, found := parsers.Lookup(test.contentType)
+			a.Equal(test.parser != nil, found, test.contentType)
+			a.Equal(test.parser, parser, test.contentType)
+		}
+	}
+}
+
+func Test_ContentParsers_RegisterReplacesAndRemoves(t *testing.T) {
+	a := assert.New(t)
+
+	parsers := NewContentParsers().
+		Register("text/html", namedParser("first")).
+		Register("text/plain", namedParser("plain")).
+		Register("TEXT/HTML", namedParser("second"))
+
+	parser, _ := parsers.Lookup("text/html")
+	a.Equal(namedParser("second"), parser)
+	a.Equal([]string{"text/plain", "text/html"}, parsers.ContentTypes())
+
+	parsers.Register("text/html", nil)
+	_, found := parsers.Lookup("text/html")
+	a.False(found)
+	a.Equal([]string{"text/plain"}, parsers.ContentTypes())
+}
+
+func Test_ContentParsers_Copy(t *testing.T) {
+	a := assert.New(t)
+
+	original := NewContentParsers().Register("text/html", namedParser("html"))
+	copied := original.Copy().Register("text/plain", namedParser("plain"))
+
+	_, found := original.Lookup("text/plain")
+	a.False(found)
+	_, found = copied.Lookup("text/html")
+	a.True(found)
+}
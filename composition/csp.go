@@ -0,0 +1,286 @@
+package composition
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/tarent/lib-compose/logging"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	ContentSecurityPolicyHeader = "Content-Security-Policy"
+	cspNone                     = "'none'"
+	cspUnsafeInline             = "'unsafe-inline'"
+)
+
+// cspFallbacks are the directives, which are used, if a fetch directive is not set in a policy.
+// All other fetch directives (*-src) fall back to the default-src.
+var cspFallbacks = map[string][]string{
+	"script-src-elem": {"script-src", "default-src"},
+	"script-src-attr": {"script-src", "default-src"},
+	"style-src-elem":  {"style-src", "default-src"},
+	"style-src-attr":  {"style-src", "default-src"},
+	"frame-src":       {"child-src", "default-src"},
+	"worker-src":      {"child-src", "script-src", "default-src"},
+}
+
+// ContentSecurityPolicy maps the directives of a Content-Security-Policy header to their sources,
+// e.g. {"script-src": {"'self'", "https://cdn.example.com"}}.
+type ContentSecurityPolicy map[string][]string
+
+// ParseContentSecurityPolicies parses the values of the Content-Security-Policy headers of one response.
+// Multiple headers and comma separated policies are returned as separate policies, because browsers enforce each of them.
+func ParseContentSecurityPolicies(headerValues ...string) []ContentSecurityPolicy {
+	policies := make([]ContentSecurityPolicy, 0, len(headerValues))
+	for _, value := range headerValues {
+		for _, policy := range strings.Split(value, ",") {
+			if strings.TrimSpace(policy) != "" {
+				policies = append(policies, ParseContentSecurityPolicy(policy))
+			}
+		}
+	}
+	return policies
+}
+
+// ParseContentSecurityPolicy parses a single policy.
+func ParseContentSecurityPolicy(policy string) ContentSecurityPolicy {
+	csp := ContentSecurityPolicy{}
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		// the first occurrence of a directive wins
+		if _, exist := csp[name]; !exist {
+			csp[name] = fields[1:]
+		}
+	}
+	return csp
+}
+
+// MergeContentSecurityPolicies returns a policy, which allows everything, which is allowed by one of the policies.
+// For each directive, the sources of all policies are joined. If a policy does not contain a fetch directive,
+// the sources of its fallback directive are taken, e.g. default-src for img-src.
+// Policies, which neither contain the directive nor a fallback, do not contribute to it.
+func MergeContentSecurityPolicies(policies ...ContentSecurityPolicy) ContentSecurityPolicy {
+	merged := ContentSecurityPolicy{}
+	for _, policy := range policies {
+		for name := range policy {
+			if _, exist := merged[name]; exist {
+				continue
+			}
+			merged[name] = []string{}
+			for _, p := range policies {
+				if sources, found := p.effectiveSources(name); found {
+					merged.addSources(name, sources...)
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// MaxContentSecurityPolicies limits the number of policies returned by MergeContentSecurityPoliciesOfContents,
+// so that the policies of the backends can not blow up the header.
+var MaxContentSecurityPolicies = 8
+
+// MergeContentSecurityPoliciesOfContents returns the policies, which allow everything, which is allowed by one of the contents.
+// Each content has a list of policies, which are all enforced, see ParseContentSecurityPolicies.
+// The policies of one content are not joined, but each of them is merged with each policy of the other contents,
+// so that the returned policies together allow exactly the union of the contents.
+// If this results in more than MaxContentSecurityPolicies, the policies of each content are joined first,
+// so that a single policy is returned, which may allow more than the contents.
+// Contents without policies do not contribute.
+func MergeContentSecurityPoliciesOfContents(contents ...[]ContentSecurityPolicy) []ContentSecurityPolicy {
+	count := 1
+	for _, policies := range contents {
+		if len(policies) > 0 && count <= MaxContentSecurityPolicies {
+			count *= len(policies)
+		}
+	}
+	if count > MaxContentSecurityPolicies {
+		logging.Logger.Warnf("joining the policies of each content, because they result in more than %v content security policies", MaxContentSecurityPolicies)
+		joined := make([][]ContentSecurityPolicy, 0, len(contents))
+		for _, policies := range contents {
+			if len(policies) > 0 {
+				joined = append(joined, []ContentSecurityPolicy{MergeContentSecurityPolicies(policies...)})
+			}
+		}
+		contents = joined
+	}
+
+	combinations := [][]ContentSecurityPolicy{{}}
+	for _, policies := range contents {
+		if len(policies) == 0 {
+			continue
+		}
+		next := make([][]ContentSecurityPolicy, 0, len(combinations)*len(policies))
+		for _, combination := range combinations {
+			for _, policy := range policies {
+				next = append(next, append(append([]ContentSecurityPolicy{}, combination...), policy))
+			}
+		}
+		combinations = next
+	}
+
+	merged := make([]ContentSecurityPolicy, 0, len(combinations))
+	for _, combination := range combinations {
+		if len(combination) > 0 {
+			merged = append(merged, MergeContentSecurityPolicies(combination...))
+		}
+	}
+	return merged
+}
+
+// effectiveSources returns the sources of a directive, or of its fallback directive.
+func (csp ContentSecurityPolicy) effectiveSources(name string) ([]string, bool) {
+	if sources, exist := csp[name]; exist {
+		return sources, true
+	}
+	fallbacks, hasFallbacks := cspFallbacks[name]
+	if !hasFallbacks && strings.HasSuffix(name, "-src") && name != "default-src" {
+		fallbacks = []string{"default-src"}
+	}
+	for _, fallback := range fallbacks {
+		if sources, exist := csp[fallback]; exist {
+			return sources, true
+		}
+	}
+	return nil, false
+}
+
+// addSources adds the sources to the directive, which are not contained yet.
+// 'none' is dropped, as soon as the directive has another source.
+func (csp ContentSecurityPolicy) addSources(name string, sources ...string) {
+	existing := csp[name]
+	for _, source := range sources {
+		if !containsFold(existing, source) {
+			existing = append(existing, source)
+		}
+	}
+	if len(existing) > 1 {
+		withoutNone := make([]string, 0, len(existing))
+		for _, source := range existing {
+			if !strings.EqualFold(source, cspNone) {
+				withoutNone = append(withoutNone, source)
+			}
+		}
+		existing = withoutNone
+	}
+	csp[name] = existing
+}
+
+// AddNonce allows the script and style elements with the nonce.
+// A missing script-src or style-src is created out of the default-src, so the nonce is only added to
+// restricted directives. Because browsers ignore 'unsafe-inline', if a nonce is present,
+// the script-src-attr and style-src-attr are kept with their former sources to still allow inline attributes.
+func (csp ContentSecurityPolicy) AddNonce(nonce string) {
+	nonceSource := "'nonce-" + nonce + "'"
+	for _, name := range []string{"script-src", "style-src"} {
+		sources, found := csp.effectiveSources(name)
+		if !found {
+			continue
+		}
+		attrDirective := name + "-attr"
+		if _, exist := csp[attrDirective]; !exist && containsFold(sources, cspUnsafeInline) {
+			csp[attrDirective] = append([]string{}, sources...)
+		}
+		csp[name] = append([]string{}, sources...)
+		csp.addSources(name, nonceSource)
+
+		if _, exist := csp[name+"-elem"]; exist {
+			csp.addSources(name+"-elem", nonceSource)
+		}
+	}
+}
+
+// String returns the policy as header value, with the directives ordered by name.
+func (csp ContentSecurityPolicy) String() string {
+	names := make([]string, 0, len(csp))
+	for name := range csp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	directives := make([]string, 0, len(names))
+	for _, name := range names {
+		directives = append(directives, strings.Join(append([]string{name}, csp[name]...), " "))
+	}
+	return strings.Join(directives, "; ")
+}
+
+// newCSPNonce returns a random base64 encoded nonce with 128 bits.
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// cspMetaJSON returns a copy of the meta JSON, where csp.nonce is set to the nonce.
+// Other entries of csp are kept. The supplied maps are not modified.
+func cspMetaJSON(metaJSON map[string]interface{}, nonce string) map[string]interface{} {
+	result := make(map[string]interface{}, len(metaJSON)+1)
+	for k, v := range metaJSON {
+		result[k] = v
+	}
+	csp := map[string]interface{}{}
+	if existing, ok := metaJSON["csp"].(map[string]interface{}); ok {
+		for k, v := range existing {
+			csp[k] = v
+		}
+	}
+	csp["nonce"] = nonce
+	result["csp"] = csp
+	return result
+}
+
+func containsFold(list []string, item string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonceWriter adds the nonce attribute to all script and style start tags of the html written through it.
+// The html is tracked by an htmlContext, so tags may be split over several writes.
+// The nonce is inserted directly after the tag name, so it takes precedence over an existing nonce attribute.
+type nonceWriter struct {
+	w         io.Writer
+	attribute []byte
+	context   htmlContext
+}
+
+func newNonceWriter(w io.Writer, nonce string) *nonceWriter {
+	return &nonceWriter{
+		w:         w,
+		attribute: []byte(` nonce="` + nonce + `"`),
+	}
+}
+
+func (nw *nonceWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+len(nw.attribute))
+	for _, b := range p {
+		c := &nw.context
+		if c.state == contextTagName && (isSpace(b) || b == '/' || b == '>') && !c.endTag && isNonceElement(c.tagName) {
+			out = append(out, nw.attribute...)
+		}
+		c.next(b)
+		out = append(out, b)
+	}
+
+	if _, err := nw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isNonceElement(tagName string) bool {
+	return tagName == "script" || tagName == "style"
+}
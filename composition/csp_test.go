@@ -0,0 +1,203 @@
+package composition
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_ParseContentSecurityPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	// when
+	csp := ParseContentSecurityPolicy("Default-Src 'self'; script-src 'self' https://cdn.example.com;; script-src *; upgrade-insecure-requests")
+
+	// then
+	a.Equal(ContentSecurityPolicy{
+		"default-src":               {"'self'"},
+		"script-src":                {"'self'", "https://cdn.example.com"},
+		"upgrade-insecure-requests": {},
+	}, csp)
+}
+
+func Test_ContentSecurityPolicy_String(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	csp := ContentSecurityPolicy{
+		"script-src":                {"'self'", "https://cdn.example.com"},
+		"default-src":               {"'none'"},
+		"upgrade-insecure-requests": {},
+	}
+
+	// then
+	a.Equal("default-src 'none'; script-src 'self' https://cdn.example.com; upgrade-insecure-requests", csp.String())
+}
+
+func Test_MergeContentSecurityPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	page := ParseContentSecurityPolicy("default-src 'self'; img-src 'self' data:")
+	fragment := ParseContentSecurityPolicy("script-src 'self' https://cdn.example.com; object-src 'none'")
+	other := ParseContentSecurityPolicy("object-src https://plugins.example.com; frame-ancestors 'none'")
+
+	// when
+	merged := MergeContentSecurityPolicies(page, fragment, other)
+
+	// then
+	a.Equal("default-src 'self'; "+
+		"frame-ancestors 'none'; "+
+		"img-src 'self' data:; "+
+		"object-src 'self' https://plugins.example.com; "+
+		"script-src 'self' https://cdn.example.com", merged.String())
+}
+
+func Test_ParseContentSecurityPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	// when
+	policies := ParseContentSecurityPolicies("script-src 'self', script-src https://a.example.com", "script-src https://b.example.com")
+
+	// then every policy is kept, because all of them are enforced
+	a.Equal([]ContentSecurityPolicy{
+		{"script-src": {"'self'"}},
+		{"script-src": {"https://a.example.com"}},
+		{"script-src": {"https://b.example.com"}},
+	}, policies)
+}
+
+func Test_MergeContentSecurityPoliciesOfContents(t *testing.T) {
+	a := assert.New(t)
+
+	// given a content with two enforced policies and another content
+	page := ParseContentSecurityPolicies("script-src 'self' https://a.example.com, script-src 'self' https://b.example.com")
+	fragment := ParseContentSecurityPolicies("script-src https://c.example.com")
+
+	// when
+	merged := MergeContentSecurityPoliciesOfContents(page, nil, fragment)
+
+	// then each policy of the page is extended by the fragment
+	a.Equal(2, len(merged))
+	a.Equal("script-src 'self' https://a.example.com https://c.example.com", merged[0].String())
+	a.Equal("script-src 'self' https://b.example.com https://c.example.com", merged[1].String())
+	a.Empty(MergeContentSecurityPoliciesOfContents(nil, nil))
+}
+
+func Test_MergeContentSecurityPoliciesOfContents_Limit(t *testing.T) {
+	a := assert.New(t)
+
+	// given contents, whose policies result in more combinations than allowed
+	contents := make([][]ContentSecurityPolicy, 0, 10)
+	for i := 0; i < 10; i++ {
+		contents = append(contents, ParseContentSecurityPolicies(
+			fmt.Sprintf("script-src https://a%v.example.com, script-src https://b%v.example.com", i, i)))
+	}
+
+	// when
+	merged := MergeContentSecurityPoliciesOfContents(contents...)
+
+	// then the policies of each content are joined into one policy
+	a.Equal(1, len(merged))
+	a.Equal(20, len(merged[0]["script-src"]))
+}
+
+func Test_ContentSecurityPolicy_AddNonce(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{
+			policy:   "script-src 'self'; style-src 'none'",
+			expected: "script-src 'self' 'nonce-abc'; style-src 'nonce-abc'",
+		},
+		{
+			policy:   "default-src 'self'; img-src *",
+			expected: "default-src 'self'; img-src *; script-src 'self' 'nonce-abc'; style-src 'self' 'nonce-abc'",
+		},
+		{
+			policy:   "img-src *",
+			expected: "img-src *",
+		},
+		{
+			policy:   "script-src 'self' 'unsafe-inline'; script-src-elem 'self'",
+			expected: "script-src 'self' 'unsafe-inline' 'nonce-abc'; script-src-attr 'self' 'unsafe-inline'; script-src-elem 'self' 'nonce-abc'",
+		},
+	}
+
+	for _, test := range tests {
+		// given
+		csp := ParseContentSecurityPolicy(test.policy)
+
+		// when
+		csp.AddNonce("abc")
+
+		// then
+		a.Equal(test.expected, csp.String(), test.policy)
+	}
+}
+
+func Test_newCSPNonce(t *testing.T) {
+	a := assert.New(t)
+
+	// when
+	nonce1, err1 := newCSPNonce()
+	nonce2, err2 := newCSPNonce()
+
+	// then
+	a.NoError(err1)
+	a.NoError(err2)
+	a.Equal(24, len(nonce1))
+	a.NotEqual(nonce1, nonce2)
+}
+
+func Test_cspMetaJSON(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	contentCsp := map[string]interface{}{"report": "/csp-report"}
+	metaJSON := map[string]interface{}{"csp": contentCsp}
+
+	// when
+	result := cspMetaJSON(metaJSON, "abc")
+
+	// then
+	a.Equal(map[string]interface{}{"report": "/csp-report", "nonce": "abc"}, result["csp"])
+	a.Equal(map[string]interface{}{"report": "/csp-report"}, contentCsp)
+	a.Equal(map[string]interface{}{"csp": contentCsp}, metaJSON)
+	a.Equal(map[string]interface{}{"csp": map[string]interface{}{"nonce": "abc"}}, cspMetaJSON(nil, "abc"))
+}
+
+func Test_nonceWriter(t *testing.T) {
+	a := assert.New(t)
+
+	html := `<SCRIPT src="a.js"></SCRIPT><style>p { color: red }</style>` +
+		`<p title="<script>">x</p><!-- <script> --><script ></script>` +
+		`<script>if (a < b) { document.write("<style>") }</script><scripts></scripts><link rel="stylesheet">`
+	expected := `<SCRIPT nonce="abc" src="a.js"></SCRIPT><style nonce="abc">p { color: red }</style>` +
+		`<p title="<script>">x</p><!-- <script> --><script nonce="abc" ></script>` +
+		`<script nonce="abc">if (a < b) { document.write("<style>") }</script><scripts></scripts><link rel="stylesheet">`
+
+	for _, chunkSize := range []int{len(html), 7, 1} {
+		// given
+		buff := &bytes.Buffer{}
+		w := newNonceWriter(buff, "abc")
+
+		// when
+		for i := 0; i < len(html); i += chunkSize {
+			end := i + chunkSize
+			if end > len(html) {
+				end = len(html)
+			}
+			n, err := w.Write([]byte(html[i:end]))
+			a.NoError(err)
+			a.Equal(end-i, n)
+		}
+
+		// then
+		a.Equal(expected, buff.String(), "chunk size %v", chunkSize)
+	}
+}
@@ -0,0 +1,84 @@
+package composition
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// The supported subset of Edge Side Includes (ESI), see https://www.w3.org/TR/esi-lang
+const (
+	EsiInclude       = "esi:include"
+	EsiRemove        = "esi:remove"
+	EsiOnErrorIgnore = "continue"
+)
+
+// getEsiInclude returns the fetch definition and the include placeholder for an esi:include element.
+// The src is fetched with the alt url as fallback and may respond with a bare html snippet. The include is optional, if onerror="continue" is set.
+func getEsiInclude(z *html.Tokenizer, tt html.TokenType, attrs []html.Attribute) (fd *FetchDefinition, placeholder string, err error) {
+	src, hasSrc := getAttr(attrs, "src")
+	if !hasSrc || strings.TrimSpace(src.Val) == "" {
+		return nil, "", fmt.Errorf("esi include without src %s", z.Raw())
+	}
+
+	// esi:include is an empty element, but may be written with an end tag
+	if tt == html.StartTagToken {
+		if err := skipCompleteTag(z, EsiInclude); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// the url fragment is not sent to the server, but would be taken as fragment name of the include
+	url := strings.Split(strings.TrimSpace(src.Val), "#")[0]
+	fd = NewFetchDefinition(url)
+	fd.Required = !attrHasValue(attrs, "onerror", EsiOnErrorIgnore)
+	if !strings.HasPrefix(url, FileURLPrefix) {
+		// response processors are not applicable on files
+		fd.WithResponseProcessor(esiSnippetProcessor{})
+	}
+	if alt, hasAlt := getAttr(attrs, "alt"); hasAlt {
+		fd.WithFallbackURL(strings.TrimSpace(alt.Val))
+	}
+
+	if fd.Required {
+		return fd, fmt.Sprintf("§[> %s]§", fd.Name), nil
+	}
+	return fd, fmt.Sprintf("§[#> %s]§§[/%s]§", fd.Name, fd.Name), nil
+}
+
+// esiSnippetProcessor wraps a bare html snippet, as served for esi:include, into a body,
+// so that the snippet becomes the default fragment of the content.
+type esiSnippetProcessor struct{}
+
+func (esiSnippetProcessor) Process(resp *http.Response, baseUrl string) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if !isHtmlDocument(body) {
+		body = append(append([]byte("<html><body>"), body...), "</body></html>"...)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// isHtmlDocument returns true, if the markup has an html, head or body element.
+func isHtmlDocument(markup []byte) bool {
+	z := html.NewTokenizer(bytes.NewReader(markup))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, _ := z.TagName()
+			switch string(tag) {
+			case "html", "head", "body":
+				return true
+			}
+		}
+	}
+}
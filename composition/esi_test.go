@@ -0,0 +1,88 @@
+package composition
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var esiIntegrationTest = `<html>
+  <head>
+    <esi:remove><link rel="stylesheet" href="/without-esi.css"/></esi:remove>
+    <title>Legacy</title>
+  </head>
+  <body>
+    <esi:include src="http://example.com/header" alt="http://fallback.example.com/header"/>
+    <esi:remove><a href="http://example.com/header">header</a></esi:remove>
+    <uic-fragment name="teaser">
+      <esi:include src="http://example.com/teaser#anchor" onerror="continue"></esi:include>
+    </uic-fragment>
+  </body>
+</html>`
+
+func Test_HtmlContentParser_Esi(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	c := NewMemoryContent()
+	parser := &HtmlContentParser{}
+
+	// when
+	err := parser.Parse(c, strings.NewReader(esiIntegrationTest))
+
+	// then
+	a.NoError(err)
+	eqFragment(t, "<title>Legacy</title>", c.Head())
+	eqFragment(t, "§[> http://example.com/header]§", c.Body()[""])
+	eqFragment(t, "§[#> http://example.com/teaser]§§[/http://example.com/teaser]§", c.Body()["teaser"])
+
+	header := NewFetchDefinition("http://example.com/header").
+		WithFallbackURL("http://fallback.example.com/header").
+		WithResponseProcessor(esiSnippetProcessor{})
+	teaser := NewFetchDefinition("http://example.com/teaser").WithResponseProcessor(esiSnippetProcessor{})
+	teaser.Required = false
+	a.Equal([]*FetchDefinition{header, teaser}, c.RequiredContent())
+}
+
+func Test_HtmlContentParser_EsiIncludeWithoutSrc(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, strings.NewReader(`<html><body><esi:include alt="/alt"/></body></html>`))
+	a.Error(err)
+}
+
+func Test_ContentFetcher_EsiIncludeOfSnippet(t *testing.T) {
+	a := assert.New(t)
+
+	// given a legacy page, which includes a bare html snippet by esi
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/nav" {
+			fmt.Fprint(w, `<div>nav</div>`)
+			return
+		}
+		fmt.Fprintf(w, `<html><body><main><esi:include src="%v/nav"/></main></body></html>`, server.URL)
+	}))
+	defer server.Close()
+
+	// when the page is fetched and merged
+	fetcher := NewContentFetcher(nil)
+	fetcher.AddFetchJob(NewFetchDefinition(server.URL + "/page").WithName(LayoutFragmentName))
+	results := fetcher.WaitForResults()
+
+	cm := NewContentMerge(fetcher.MetaJSON())
+	for _, res := range results {
+		a.NoError(res.Err)
+		cm.AddContent(res.Content, res.Def.Priority)
+	}
+	html, err := cm.GetHtml()
+
+	// then the snippet is included as default fragment
+	a.NoError(err)
+	a.Contains(string(html), "<main><div>nav</div></main>")
+}
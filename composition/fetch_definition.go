@@ -32,6 +32,8 @@ var ForwardRequestHeaders = []string{
 	"X-Correlation-Id",
 	"X-Feature-Toggle",
 	"Host",
+	"Traceparent",
+	"Tracestate",
 }
 
 // ForwardResponseHeaders are those headers,
@@ -76,6 +78,12 @@ type FetchDefinition struct {
 	ServiceDiscoveryActive bool
 	ServiceDiscovery       servicediscovery.ServiceDiscovery
 	Priority               int
+
+	// RetryPolicy defines the repetition of failed fetches, no fetch is repeated if nil.
+	RetryPolicy *RetryPolicy
+
+	// FallbackURL is fetched instead, if fetching the URL fails, e.g. the alt url of an esi:include.
+	FallbackURL string
 }
 
 // Creates a fetch definition (warning: this one will not forward any request headers).
@@ -99,6 +107,18 @@ func (fd *FetchDefinition) WithPriority(priority int) *FetchDefinition {
 	return fd
 }
 
+// WithRetryPolicy sets a policy for repeating failed fetches, e.g. &DefaultRetryPolicy.
+func (fd *FetchDefinition) WithRetryPolicy(policy *RetryPolicy) *FetchDefinition {
+	fd.RetryPolicy = policy
+	return fd
+}
+
+// WithFallbackURL sets a url, which is fetched, if fetching the URL fails.
+func (fd *FetchDefinition) WithFallbackURL(url string) *FetchDefinition {
+	fd.FallbackURL = url
+	return fd
+}
+
 // Use a given request to extract a path, method and body for the fetch request
 func (fd *FetchDefinition) FromRequest(r *http.Request) *FetchDefinition {
 	if strings.HasSuffix(fd.URL, "/") {
@@ -156,6 +176,14 @@ func (def *FetchDefinition) IsCacheable(responseStatus int, responseHeaders http
 	return false
 }
 
+// EntryOptions returns the settings for the cache entry of a response.
+func (def *FetchDefinition) EntryOptions(responseStatus int, responseHeaders http.Header) cache.EntryOptions {
+	if def.CacheStrategy != nil {
+		return def.CacheStrategy.EntryOptions(def.Method, def.URL, responseStatus, def.Header, responseHeaders)
+	}
+	return cache.EntryOptions{}
+}
+
 func (def *FetchDefinition) IsReadableFromCache() bool {
 	return def.IsCacheable(200, nil)
 }
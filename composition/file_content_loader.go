@@ -1,9 +1,13 @@
 package composition
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/metrics"
+	"github.com/tarent/lib-compose/tracing"
+	"mime"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,19 +17,33 @@ import (
 var ResponseProcessorsNotApplicable = errors.New("request processors are not apliable on file content")
 
 type FileContentLoader struct {
-	parser ContentParser
+	parsers *ContentParsers
 }
 
 func NewFileContentLoader() *FileContentLoader {
 	return &FileContentLoader{
-		parser: &HtmlContentParser{},
+		parsers: DefaultContentParsers.Copy(),
 	}
 }
 
-func (loader *FileContentLoader) Load(fd *FetchDefinition) (Content, error) {
+// RegisterParser sets the parser for files of the content type.
+// The content type of a file is derived from its extension by mime.TypeByExtension,
+// so additional extensions can be added by mime.AddExtensionType.
+func (loader *FileContentLoader) RegisterParser(contentType string, parser ContentParser) *FileContentLoader {
+	if loader.parsers == nil {
+		loader.parsers = NewContentParsers()
+	}
+	loader.parsers.Register(contentType, parser)
+	return loader
+}
+
+func (loader *FileContentLoader) Load(ctx context.Context, fd *FetchDefinition) (Content, error) {
 	if fd.RespProc != nil {
 		return nil, ResponseProcessorsNotApplicable
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	path := strings.TrimPrefix(fd.URL, FileURLPrefix)
 	stat, err := os.Stat(path)
@@ -47,9 +65,16 @@ func (loader *FileContentLoader) Load(fd *FetchDefinition) (Content, error) {
 	c.name = fd.Name
 	c.httpStatusCode = 200
 
-	if strings.HasSuffix(path, ".html") {
+	if parser, found := loader.parsers.Lookup(mime.TypeByExtension(filepath.Ext(path))); found {
 		parsingStart := time.Now()
-		err := loader.parser.Parse(c, f)
+		_, span := tracing.Default.Start(ctx, "ContentParser.Parse")
+		span.SetAttribute("path", path)
+		err := parser.Parse(c, f)
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+		metrics.Default.Parse(time.Since(parsingStart))
 		logging.Logger.
 			WithField("full_url", fd.URL).
 			WithField("duration", time.Since(parsingStart)).
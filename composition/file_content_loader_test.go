@@ -1,8 +1,10 @@
 package composition
 
 import (
+	"context"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -26,7 +28,7 @@ func Test_FileContentLoader_LoadHTML(t *testing.T) {
 	loader := NewFileContentLoader()
 	fd := NewFetchDefinition(FileURLPrefix + fileName)
 	fd.Name = "content"
-	c, err := loader.Load(fd)
+	c, err := loader.Load(context.Background(), fd)
 	a.Equal("content", c.Name())
 	assertContentLoaded(t, c, err, "some head content")
 }
@@ -41,10 +43,35 @@ func Test_FileContentLoader_LoadIndexForDirectory(t *testing.T) {
 	a.NoError(err)
 
 	loader := NewFileContentLoader()
-	c, err := loader.Load(NewFetchDefinition(FileURLPrefix + dir))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(FileURLPrefix+dir))
 	assertContentLoaded(t, c, err, "some head content")
 }
 
+func Test_FileContentLoader_RegisterParser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	fileName := filepath.Join(os.TempDir(), randString(10)+".json")
+	err := ioutil.WriteFile(fileName, []byte(`{"some": "json"}`), 0660)
+	a.NoError(err)
+	defer os.Remove(fileName)
+
+	mockParser := NewMockContentParser(ctrl)
+	mockParser.EXPECT().Parse(gomock.Any(), gomock.Any()).
+		Do(func(c *MemoryContent, in io.Reader) {
+			c.head = StringFragment("some head content")
+		})
+
+	loader := NewFileContentLoader().RegisterParser("application/json", mockParser)
+	c, err := loader.Load(context.Background(), NewFetchDefinition(FileURLPrefix+fileName))
+	assertContentLoaded(t, c, err, "some head content")
+
+	// the default parsers are not affected
+	_, found := DefaultContentParsers.Lookup("application/json")
+	a.False(found)
+}
+
 func assertContentLoaded(t *testing.T, c Content, err error, s string) {
 	a := assert.New(t)
 	a.NoError(err)
@@ -63,7 +90,7 @@ func Test_FileContentLoader_LoadStream(t *testing.T) {
 	a.NoError(err)
 
 	loader := NewFileContentLoader()
-	c, err := loader.Load(NewFetchDefinition(FileURLPrefix + fileName))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(FileURLPrefix+fileName))
 	a.NoError(err)
 	a.NotNil(c)
 	body, err := ioutil.ReadAll(c.Reader())
@@ -81,7 +108,7 @@ func Test_FileContentLoader_LoadError(t *testing.T) {
 	f.Close()
 
 	loader := NewFileContentLoader()
-	_, err = loader.Load(NewFetchDefinition(f.Name()))
+	_, err = loader.Load(context.Background(), NewFetchDefinition(f.Name()))
 	a.Error(err)
 }
 
@@ -89,7 +116,7 @@ func Test_FileContentLoader_Return404IfFileNotFound(t *testing.T) {
 	a := assert.New(t)
 
 	loader := NewFileContentLoader()
-	c, err := loader.Load(NewFetchDefinition("/tmp/some/non/existing/path"))
+	c, err := loader.Load(context.Background(), NewFetchDefinition("/tmp/some/non/existing/path"))
 	a.NotNil(c)
 	a.Error(err)
 	a.Equal(404, c.HttpStatusCode())
@@ -103,7 +130,7 @@ func Test_FileContentLoader_RequestProcessor(t *testing.T) {
 	fd := NewFetchDefinition("/tmp/some/non/existing/path")
 	fd.RespProc = NewMockResponseProcessor(ctrl)
 
-	_, err := NewFileContentLoader().Load(fd)
+	_, err := NewFileContentLoader().Load(context.Background(), fd)
 	a.Equal(ResponseProcessorsNotApplicable, err)
 }
 
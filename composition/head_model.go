@@ -0,0 +1,322 @@
+package composition
+
+import (
+	"bytes"
+	"github.com/tarent/lib-compose/logging"
+	"golang.org/x/net/html"
+	"io"
+	"strings"
+)
+
+// headSeparator is written between the elements of the head
+const headSeparator = "\n    "
+
+// HeadModel is the structured representation of the merged <head>.
+// The head fragments are parsed into their elements, which are merged by their keys:
+//
+//   - The charset, the base and the title exist only once.
+//   - Meta elements are merged by their name, property or http-equiv.
+//   - Link elements are merged by rel, media and href, scripts by their src and style elements by their content.
+//
+// On collisions, the element of the content with the higher priority wins, and for equal or unset priorities
+// the one added first, e.g. the title of the layout. Merged elements keep the position of their first occurrence,
+// so that the resources are loaded in the order of the contents.
+//
+// The head is written in the order charset, base, title, meta elements, followed by all other elements in the order of the contents.
+// Elements within template blocks, e.g. of uic-if, and fragments, which are no StringFragment, are not merged.
+type HeadModel struct {
+	charset  *headElement
+	base     *headElement
+	title    *headElement
+	meta     []*headElement
+	elements []*headElement // resources, other markup and custom fragments
+	keys     map[string]*headElement
+}
+
+type headElementKind int
+
+const (
+	headOther headElementKind = iota
+	headCharset
+	headBase
+	headTitle
+	headMeta
+	headResource
+)
+
+type headElement struct {
+	kind     headElementKind
+	key      string
+	fragment Fragment
+	priority int
+}
+
+func NewHeadModel() *HeadModel {
+	return &HeadModel{
+		meta:     make([]*headElement, 0),
+		elements: make([]*headElement, 0),
+		keys:     make(map[string]*headElement),
+	}
+}
+
+// Add merges the elements of a head fragment into the model.
+// A fragment, which is no StringFragment or can not be parsed, is added as a whole.
+func (head *HeadModel) Add(f Fragment, priority int) {
+	sf, isString := f.(StringFragment)
+	if !isString {
+		head.elements = append(head.elements, &headElement{kind: headOther, fragment: f, priority: priority})
+		return
+	}
+
+	elements, err := parseHeadElements(string(sf))
+	if err != nil {
+		head.elements = append(head.elements, &headElement{kind: headOther, fragment: f, priority: priority})
+		return
+	}
+	for _, e := range elements {
+		e.priority = priority
+		head.addElement(e)
+	}
+}
+
+func (head *HeadModel) addElement(e *headElement) {
+	switch e.kind {
+	case headCharset:
+		head.charset = mergeHeadElement(head.charset, e)
+	case headBase:
+		head.base = mergeHeadElement(head.base, e)
+	case headTitle:
+		head.title = mergeHeadElement(head.title, e)
+	case headMeta:
+		if head.mergeByKey(e) {
+			head.meta = append(head.meta, e)
+		}
+	default:
+		if head.mergeByKey(e) {
+			head.elements = append(head.elements, e)
+		}
+	}
+}
+
+// mergeByKey merges the element into the element with the same key and returns true, if it is a new element.
+func (head *HeadModel) mergeByKey(e *headElement) bool {
+	if e.key == "" {
+		return true
+	}
+	if existing, found := head.keys[e.key]; found {
+		mergeHeadElement(existing, e)
+		return false
+	}
+	head.keys[e.key] = e
+	return true
+}
+
+// mergeHeadElement replaces the content of the existing element, if the new element has a higher priority.
+func mergeHeadElement(existing, e *headElement) *headElement {
+	if existing == nil {
+		return e
+	}
+	if e.priority > existing.priority {
+		existing.fragment, existing.priority = e.fragment, e.priority
+	}
+	return existing
+}
+
+// Fragments returns the fragments of the head in the order, in which they are written.
+func (head *HeadModel) Fragments() []Fragment {
+	fragments := make([]Fragment, 0, 3+len(head.meta)+len(head.elements))
+	for _, e := range []*headElement{head.charset, head.base, head.title} {
+		if e != nil {
+			fragments = append(fragments, e.fragment)
+		}
+	}
+	for _, e := range head.meta {
+		fragments = append(fragments, e.fragment)
+	}
+	for _, e := range head.elements {
+		fragments = append(fragments, e.fragment)
+	}
+	return fragments
+}
+
+// Write executes the fragments of the head, separated by line breaks.
+func (head *HeadModel) Write(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+	for i, f := range head.Fragments() {
+		if i > 0 {
+			io.WriteString(w, headSeparator)
+		}
+		if err := f.Execute(w, data, executeNestedFragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceMap returns the keys of the resources of the head, in the form used by removeDuplicateResources.
+func (head *HeadModel) resourceMap() map[string]string {
+	resources := make(map[string]string)
+	for key, e := range head.keys {
+		if e.kind == headResource {
+			resources[key] = strings.SplitN(key, "_", 2)[0] // the tag name
+		}
+	}
+	return resources
+}
+
+// lateHeadFragment returns the part of a head fragment, which is written into the body,
+// because the head was already written, when its content was added.
+// The charset, the base, the title and meta elements are dropped, because they have no effect within the body,
+// and the resources contained in the resourceMap are removed. Fragments, which are no StringFragment, are returned as they are.
+func lateHeadFragment(f Fragment, resourceMap map[string]string) Fragment {
+	sf, isString := f.(StringFragment)
+	if !isString {
+		return f
+	}
+	elements, err := parseHeadElements(string(sf))
+	if err != nil {
+		return removeDuplicateResourcesOf(f, resourceMap)
+	}
+
+	buff := bytes.NewBuffer(nil)
+	for _, e := range elements {
+		switch e.kind {
+		case headResource:
+			if e.key != "" {
+				if _, exist := resourceMap[e.key]; exist {
+					continue
+				}
+				resourceMap[e.key] = strings.SplitN(e.key, "_", 2)[0]
+			}
+		case headCharset, headBase, headTitle, headMeta:
+			logging.Logger.Warnf("dropped %v of a content, which was loaded after the head was written", e.fragment)
+			continue
+		}
+		if buff.Len() > 0 {
+			buff.WriteString(headSeparator)
+		}
+		buff.WriteString(string(e.fragment.(StringFragment)))
+	}
+	return StringFragment(buff.String())
+}
+
+// parseHeadElements splits a head fragment into its top level elements.
+// Whitespace between the elements is dropped. Template blocks and elements,
+// which are not part of the model, e.g. noscript, are kept as a whole.
+func parseHeadElements(fragment string) ([]*headElement, error) {
+	elements := make([]*headElement, 0)
+	addOther := func(raw []byte) {
+		if s := strings.TrimSpace(string(raw)); s != "" {
+			elements = append(elements, &headElement{kind: headOther, fragment: StringFragment(s)})
+		}
+	}
+
+	attrs := make([]html.Attribute, 0, 10)
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		tt := z.Next()
+		tag, _ := z.TagName()
+		raw := byteCopy(z.Raw()) // create a copy here, because readAttributes modifies z.Raw, if attributes contain an &
+		attrs = readAttributes(z, attrs)
+		tagName := string(tag)
+
+		switch {
+		case tt == html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			return elements, nil
+
+		case tt == html.TextToken:
+			if templateBlockDepth(string(raw)) <= 0 {
+				addOther(raw)
+				continue
+			}
+			block, err := readTemplateBlock(z, raw)
+			if err != nil {
+				return nil, err
+			}
+			addOther(block)
+
+		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
+			element := bytes.NewBuffer(raw)
+			content := []byte{}
+			if tt == html.StartTagToken && !voidElements[tagName] {
+				var err error
+				if content, err = readElementContent(z, tagName, element); err != nil {
+					return nil, err
+				}
+			}
+			e := newHeadElement(tagName, attrs, element.Bytes(), content)
+			if e == nil {
+				addOther(element.Bytes())
+				continue
+			}
+			elements = append(elements, e)
+
+		default:
+			addOther(raw)
+		}
+	}
+}
+
+// newHeadElement returns the element of the model or nil, if the element is not part of the model.
+func newHeadElement(tagName string, attrs []html.Attribute, raw []byte, content []byte) *headElement {
+	e := &headElement{fragment: StringFragment(raw)}
+	switch tagName {
+	case "meta":
+		e.kind, e.key = headMeta, metaKey(attrs)
+		if e.key == "charset" {
+			e.kind = headCharset
+		}
+	case "base":
+		e.kind, e.key = headBase, "base"
+	case "title":
+		e.kind, e.key = headTitle, "title"
+	case "link", "script", "style":
+		e.kind = headResource
+		if isResourceElement(tagName, attrs) {
+			e.key = resourceKey(tagName, attrs, content)
+		}
+	default:
+		return nil
+	}
+	return e
+}
+
+// metaKey returns the key of a meta element by its name, property or http-equiv,
+// or "charset", if it declares the character encoding.
+func metaKey(attrs []html.Attribute) string {
+	if _, found := getAttr(attrs, "charset"); found {
+		return "charset"
+	}
+	for _, name := range []string{"name", "property", "http-equiv"} {
+		if a, found := getAttr(attrs, name); found {
+			value := strings.ToLower(strings.TrimSpace(a.Val))
+			if name == "http-equiv" && value == "content-type" {
+				return "charset"
+			}
+			return "meta_" + name + "_" + value
+		}
+	}
+	return ""
+}
+
+// readTemplateBlock reads all tokens, up to the end of the template block opened in the text.
+func readTemplateBlock(z *html.Tokenizer, text []byte) ([]byte, error) {
+	block := bytes.NewBuffer(text)
+	depth := templateBlockDepth(string(text))
+	for depth > 0 {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			break
+		}
+		block.Write(z.Raw())
+		if tt == html.TextToken {
+			depth += templateBlockDepth(string(z.Raw()))
+		}
+	}
+	return block.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package composition
+
+import (
+	"bytes"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func Test_HeadModel_MergesAndOrdersElements(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	head := NewHeadModel()
+	head.Add(StringFragment(`
+    <title>Layout</title>
+    <link rel="stylesheet" href="/base.css">
+    <meta name="description" content="layout">
+    <script src="/jquery.js"></script>
+    <meta charset="utf-8">
+    <noscript><link rel="stylesheet" href="/noscript.css"></noscript>
+  `), 0)
+	head.Add(StringFragment(`
+    <meta name="Description" content="fragment">
+    <meta property="og:title" content="Fragment">
+    <title>Fragment</title>
+    <link rel="stylesheet" href="/base.css">
+    <script>init()</script>
+    <base href="/app/">
+  `), 0)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := head.Write(buff, nil, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(`<meta charset="utf-8">
+    <base href="/app/">
+    <title>Layout</title>
+    <meta name="description" content="layout">
+    <meta property="og:title" content="Fragment">
+    <link rel="stylesheet" href="/base.css">
+    <script src="/jquery.js"></script>
+    <noscript><link rel="stylesheet" href="/noscript.css"></noscript>
+    <script>init()</script>`, buff.String())
+}
+
+func Test_HeadModel_Priority(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	head := NewHeadModel()
+	head.Add(StringFragment(`<title>high</title><link rel="icon" href="/favicon.ico" id="high"><meta http-equiv="Content-Type" content="text/html; charset=utf-8">`), 2)
+	head.Add(StringFragment(`<script src="/first.js"></script><title>low</title><meta charset="iso-8859-1">`), 1)
+	head.Add(StringFragment(`<link rel="icon" href="/favicon.ico" id="highest">`), 3)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := head.Write(buff, nil, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(`<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+    <title>high</title>
+    <link rel="icon" href="/favicon.ico" id="highest">
+    <script src="/first.js"></script>`, buff.String())
+}
+
+func Test_HeadModel_TemplateBlocksAreNotMerged(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	head := NewHeadModel()
+	head.Add(StringFragment(`<title>default</title>§[?special]§<title>special</title>§[:?]§<link rel="stylesheet" href="/a.css">§[/?]§<link rel="stylesheet" href="/a.css">`), 0)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := head.Write(buff, map[string]interface{}{"special": true}, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(`<title>default</title>
+    <title>special</title>
+    <link rel="stylesheet" href="/a.css">`, buff.String())
+}
+
+func Test_HeadModel_CustomFragment(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given
+	custom := NewMockFragment(ctrl)
+	custom.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(w io.Writer, data map[string]interface{}, executeNestedFragment func(string) error) {
+			io.WriteString(w, "<custom-head/>")
+		}).
+		Return(nil)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		head: StringFragment(`<title>Layout</title>`),
+		body: map[string]Fragment{"": StringFragment("layout")},
+	}, 0)
+	cm.AddContent(&MemoryContent{
+		name: "custom",
+		head: custom,
+	}, MAX_PRIORITY)
+
+	// when
+	html, err := cm.GetHtml()
+
+	// then
+	a.NoError(err)
+	a.Contains(string(html), "<title>Layout</title>\n    <custom-head/>\n  </head>")
+}
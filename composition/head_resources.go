@@ -0,0 +1,121 @@
+package composition
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// isResourceElement checks, if the element loads or contains a resource, which should only be included once.
+func isResourceElement(tagName string, attrs []html.Attribute) bool {
+	switch tagName {
+	case "link":
+		_, hasHref := getAttr(attrs, "href")
+		return hasHref
+	case "script":
+		_, hasSrc := getAttr(attrs, "src")
+		return hasSrc
+	case "style":
+		return true
+	}
+	return false
+}
+
+// processResource writes the resource element to the buffer, if its key is not contained in the resourceMap.
+// The content and the end tag of script and style elements are read from the tokenizer.
+func processResource(z *html.Tokenizer, tt html.TokenType, tagName string, attrs []html.Attribute, raw []byte, buff *bytes.Buffer, resourceMap map[string]string) error {
+	element := bytes.NewBuffer(raw)
+	content := []byte{}
+	if tt == html.StartTagToken && !voidElements[tagName] {
+		var err error
+		if content, err = readElementContent(z, tagName, element); err != nil {
+			return err
+		}
+	}
+
+	key := resourceKey(tagName, attrs, content)
+	if _, exist := resourceMap[key]; exist {
+		return nil
+	}
+	resourceMap[key] = tagName
+	buff.Write(element.Bytes())
+	return nil
+}
+
+// readElementContent writes all tokens up to the end tag to the raw buffer and returns the text of the element.
+func readElementContent(z *html.Tokenizer, tagName string, raw *bytes.Buffer) ([]byte, error) {
+	text := bytes.NewBuffer(nil)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			return text.Bytes(), nil
+		}
+		raw.Write(z.Raw())
+		if tt == html.TextToken {
+			text.Write(z.Raw())
+		}
+		if tag, _ := z.TagName(); tt == html.EndTagToken && string(tag) == tagName {
+			return text.Bytes(), nil
+		}
+	}
+}
+
+// resourceKey returns the key, by which duplicate resources are detected
+func resourceKey(tagName string, attrs []html.Attribute, content []byte) string {
+	media, _ := getAttr(attrs, "media")
+	switch tagName {
+	case "link":
+		rel, _ := getAttr(attrs, "rel")
+		href, _ := getAttr(attrs, "href")
+		return "link_" + strings.ToLower(strings.TrimSpace(rel.Val)) + "_" + media.Val + "_" + normalizeURL(href.Val)
+	case "script":
+		src, _ := getAttr(attrs, "src")
+		return "script_" + normalizeURL(src.Val)
+	}
+	hash := sha256.Sum256(bytes.TrimSpace(content))
+	return tagName + "_" + media.Val + "_" + hex.EncodeToString(hash[:])
+}
+
+// normalizeURL returns the url in a normalized form, so that different spellings of the same url are equal.
+// The scheme and the host are lower cased, and default ports, dot segments of the path and the fragment are removed.
+func normalizeURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+
+	if strings.HasPrefix(u.Path, "/") {
+		cleaned := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path, u.RawPath = cleaned, ""
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+// templateBlockDepth returns the number of template blocks, which are opened minus the ones, which are closed in the text.
+// Blocks are conditions, loops and the alternative content of includes.
+func templateBlockDepth(text string) int {
+	opened := strings.Count(text, PlaceholderStart+StartCondition) +
+		strings.Count(text, PlaceholderStart+StartLoop) +
+		strings.Count(text, PlaceholderStart+StartIncludeBlock)
+	closed := strings.Count(text, PlaceholderStart+EndIncludeBlock)
+	return opened - closed
+}
@@ -0,0 +1,37 @@
+package composition
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_normalizeURL(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{" /shared/base.css ", "/shared/base.css"},
+		{"/shared/./css/../base.css", "/shared/base.css"},
+		{"/shared/", "/shared/"},
+		{"HTTP://Example.COM:80/a.js#x", "http://example.com/a.js"},
+		{"https://example.com:443/a.js?v=1", "https://example.com/a.js?v=1"},
+		{"https://example.com:8443/a.js", "https://example.com:8443/a.js"},
+		{"//cdn.example.com/a.js", "//cdn.example.com/a.js"},
+		{"js/app.js", "js/app.js"},
+	}
+
+	for _, test := range tests {
+		a.Equal(test.expected, normalizeURL(test.url), test.url)
+	}
+}
+
+func Test_templateBlockDepth(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(0, templateBlockDepth("§[ foo ]§ §[> bar]§"))
+	a.Equal(1, templateBlockDepth("§[?foo]§"))
+	a.Equal(2, templateBlockDepth("§[* list]§ §[#> bar]§ §[:*]§"))
+	a.Equal(-1, templateBlockDepth("§[/?]§ §[/*]§ §[? foo]§"))
+}
@@ -0,0 +1,112 @@
+package composition
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"strings"
+)
+
+// layoutAttributes are taken from the layout, if it sets them, independent of the priorities
+var layoutAttributes = map[string]bool{
+	"lang": true,
+	"dir":  true,
+}
+
+// HtmlAttributes merges the attributes of the html elements of the contents:
+//
+//   - The values of the class attributes are joined.
+//   - The lang and the dir are taken from the layout, if it sets them.
+//   - For all other attributes, the value of the content with the higher priority wins,
+//     and for equal priorities the one added last.
+//
+// Fragments, which are no StringFragment, are written after the merged attributes, as they are.
+type HtmlAttributes struct {
+	attrs      []html.Attribute // in order of their first occurrence
+	priorities map[string]int
+	fromLayout map[string]bool
+	fragments  []Fragment
+}
+
+func NewHtmlAttributes() *HtmlAttributes {
+	return &HtmlAttributes{
+		attrs:      make([]html.Attribute, 0),
+		priorities: make(map[string]int),
+		fromLayout: make(map[string]bool),
+		fragments:  make([]Fragment, 0),
+	}
+}
+
+// Add merges the attributes of a content. isLayout marks the attributes of the layout.
+func (ha *HtmlAttributes) Add(f Fragment, priority int, isLayout bool) {
+	sf, isString := f.(StringFragment)
+	if !isString {
+		ha.fragments = append(ha.fragments, f)
+		return
+	}
+
+	z := html.NewTokenizer(strings.NewReader("<html " + string(sf) + ">"))
+	if z.Next() != html.StartTagToken {
+		ha.fragments = append(ha.fragments, f)
+		return
+	}
+	for _, a := range readAttributes(z, make([]html.Attribute, 0, 10)) {
+		ha.addAttribute(a, priority, isLayout)
+	}
+}
+
+func (ha *HtmlAttributes) addAttribute(a html.Attribute, priority int, isLayout bool) {
+	i := ha.indexOf(a.Key)
+	if i == -1 {
+		ha.attrs = append(ha.attrs, a)
+		ha.priorities[a.Key], ha.fromLayout[a.Key] = priority, isLayout
+		return
+	}
+
+	switch {
+	case a.Key == "class":
+		ha.attrs[i].Val = joinClasses(ha.attrs[i].Val, a.Val)
+	case layoutAttributes[a.Key] && ha.fromLayout[a.Key] != isLayout:
+		if isLayout {
+			ha.attrs[i].Val, ha.priorities[a.Key], ha.fromLayout[a.Key] = a.Val, priority, true
+		}
+	case priority >= ha.priorities[a.Key]:
+		ha.attrs[i].Val, ha.priorities[a.Key] = a.Val, priority
+	}
+}
+
+func (ha *HtmlAttributes) indexOf(key string) int {
+	for i, a := range ha.attrs {
+		if a.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// joinClasses appends the classes, which are not contained yet
+func joinClasses(classes string, additional string) string {
+	existing := strings.Fields(classes)
+	for _, class := range strings.Fields(additional) {
+		if !contains(existing, class) {
+			existing = append(existing, class)
+		}
+	}
+	return strings.Join(existing, " ")
+}
+
+// Write writes the merged attributes, each prefixed by a space.
+func (ha *HtmlAttributes) Write(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+	if len(ha.attrs) > 0 {
+		io.WriteString(w, " ")
+		if err := StringFragment(joinAttrs(ha.attrs)).Execute(w, data, executeNestedFragment); err != nil {
+			return err
+		}
+	}
+	for _, f := range ha.fragments {
+		io.WriteString(w, " ")
+		if err := f.Execute(w, data, executeNestedFragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
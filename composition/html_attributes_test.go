@@ -0,0 +1,71 @@
+package composition
+
+import (
+	"bytes"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func Test_HtmlAttributes_Merge(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	ha := NewHtmlAttributes()
+	ha.Add(StringFragment(`class="page" data-theme="dark" dir="rtl"`), 0, false)
+	ha.Add(StringFragment(`lang="de" class="no-js  page"`), 0, true)
+	ha.Add(StringFragment(`lang="en" class="fragment" data-theme="light" dir="ltr"`), 5, false)
+	ha.Add(StringFragment(`data-theme="blue"`), 1, false)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := ha.Write(buff, nil, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(` class="page no-js fragment" data-theme="light" dir="ltr" lang="de"`, buff.String())
+}
+
+func Test_HtmlAttributes_LangWithoutLayout(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	ha := NewHtmlAttributes()
+	ha.Add(StringFragment(`lang="de"`), 0, false)
+	ha.Add(StringFragment(`lang="en"`), 0, false)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := ha.Write(buff, nil, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(` lang="en"`, buff.String())
+}
+
+func Test_HtmlAttributes_Templates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	// given
+	custom := NewMockFragment(ctrl)
+	custom.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(w io.Writer, data map[string]interface{}, executeNestedFragment func(string) error) {
+			io.WriteString(w, `data-custom="x"`)
+		}).
+		Return(nil)
+
+	ha := NewHtmlAttributes()
+	ha.Add(StringFragment(`lang="§[ lang ]§"`), 0, true)
+	ha.Add(custom, 0, false)
+
+	// when
+	buff := bytes.NewBuffer(nil)
+	err := ha.Write(buff, map[string]interface{}{"lang": "fr"}, nil)
+
+	// then
+	a.NoError(err)
+	a.Equal(` lang="fr" data-custom="x"`, buff.String())
+}
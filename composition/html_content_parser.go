@@ -17,6 +17,8 @@ const (
 	UicFetch        = "uic-fetch"
 	UicFragment     = "uic-fragment"
 	UicTail         = "uic-tail"
+	UicIf           = "uic-if"
+	UicLoop         = "uic-loop"
 	ScriptTypeMeta  = "text/uic-meta"
 	ParamAttrPrefix = "param-"
 )
@@ -37,6 +39,8 @@ func (parser *HtmlContentParser) Parse(c *MemoryContent, in io.Reader) error {
 		case tt == html.StartTagToken:
 			tag, _ := z.TagName()
 			switch string(tag) {
+			case "html":
+				parser.parseHtmlAttributes(z, c)
 			case "head":
 				if err := parser.parseHead(z, c); err != nil {
 					return err
@@ -50,9 +54,17 @@ func (parser *HtmlContentParser) Parse(c *MemoryContent, in io.Reader) error {
 	}
 }
 
+func (parser *HtmlContentParser) parseHtmlAttributes(z *html.Tokenizer, c *MemoryContent) {
+	attrs := readAttributes(z, make([]html.Attribute, 0, 10))
+	if len(attrs) > 0 {
+		c.htmlAttributes = StringFragment(joinAttrs(attrs))
+	}
+}
+
 func (parser *HtmlContentParser) parseHead(z *html.Tokenizer, c *MemoryContent) error {
 	attrs := make([]html.Attribute, 0, 10)
 	headBuff := bytes.NewBuffer(nil)
+	blocks := &blockElements{}
 
 forloop:
 	for {
@@ -82,8 +94,11 @@ forloop:
 				break forloop
 			}
 		}
-		headBuff.Write(raw)
+		if err := blocks.write(headBuff, tt, string(tag), attrs, raw); err != nil {
+			return err
+		}
 	}
+	blocks.closeAll(headBuff)
 
 	s := headBuff.String()
 	st := strings.Trim(s, " \n")
@@ -96,6 +111,7 @@ forloop:
 func (parser *HtmlContentParser) parseBody(z *html.Tokenizer, c *MemoryContent) error {
 	attrs := make([]html.Attribute, 0, 10)
 	bodyBuff := bytes.NewBuffer(nil)
+	blocks := &blockElements{}
 
 	attrs = readAttributes(z, attrs)
 	if len(attrs) > 0 {
@@ -120,24 +136,18 @@ forloop:
 				continue
 			}
 			if string(tag) == UicFragment {
-				if f, deps, err := parseFragment(z); err != nil {
+				if f, err := parseFragment(z, c); err != nil {
 					return err
 				} else {
 					c.body[getFragmentName(attrs)] = f
-					for depName, depParams := range deps {
-						c.dependencies[depName] = depParams
-					}
 				}
 				continue
 			}
 			if string(tag) == UicTail {
-				if f, deps, err := parseFragment(z); err != nil {
+				if f, err := parseFragment(z, c); err != nil {
 					return err
 				} else {
 					c.tail = f
-					for depName, depParams := range deps {
-						c.dependencies[depName] = depParams
-					}
 				}
 				continue
 			}
@@ -145,30 +155,41 @@ forloop:
 				if fd, err := getFetch(z, attrs); err != nil {
 					return err
 				} else {
-					c.requiredContent[fd.URL] = fd
+					c.addRequiredContent(fd)
 					continue
 				}
 			}
 			if string(tag) == UicInclude {
-				if replaceTextStart, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, attrs); err != nil {
+				if replaceTextStart, alternative, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, tt, attrs); err != nil {
 					return err
 				} else {
-					c.dependencies[dependencyName] = dependencyParams
+					c.addDependency(dependencyName, dependencyParams)
 					bodyBuff.WriteString(replaceTextStart)
-					// Enhancement: WriteOut sub tree, to allow alternative content
-					//              for optional includes.
+					bodyBuff.WriteString(alternative)
 					bodyBuff.WriteString(replaceTextEnd)
 					continue
 				}
 			}
+			if string(tag) == EsiInclude {
+				if fd, placeholder, err := getEsiInclude(z, tt, attrs); err != nil {
+					return err
+				} else {
+					c.addRequiredContent(fd)
+					bodyBuff.WriteString(placeholder)
+					continue
+				}
+			}
 
 		case tt == html.EndTagToken:
 			if string(tag) == "body" {
 				break forloop
 			}
 		}
-		bodyBuff.Write(raw)
+		if err := blocks.write(bodyBuff, tt, string(tag), attrs, raw); err != nil {
+			return err
+		}
 	}
+	blocks.closeAll(bodyBuff)
 
 	s := bodyBuff.String()
 	if _, defaultFragmentExists := c.body[""]; !defaultFragmentExists {
@@ -180,9 +201,10 @@ forloop:
 	return nil
 }
 
-func parseFragment(z *html.Tokenizer) (f Fragment, dependencies map[string]Params, err error) {
+// parseFragment parses an uic-fragment or uic-tail element and adds its includes to the content.
+func parseFragment(z *html.Tokenizer, c *MemoryContent) (f Fragment, err error) {
 	attrs := make([]html.Attribute, 0, 10)
-	dependencies = make(map[string]Params)
+	blocks := &blockElements{}
 
 	buff := bytes.NewBuffer(nil)
 forloop:
@@ -195,19 +217,27 @@ forloop:
 		switch {
 		case tt == html.ErrorToken:
 			if z.Err() != io.EOF {
-				return nil, nil, z.Err()
+				return nil, z.Err()
 			}
 			break forloop
 		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
 			if string(tag) == UicInclude {
-				if replaceTextStart, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, attrs); err != nil {
-					return nil, nil, err
+				if replaceTextStart, alternative, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, tt, attrs); err != nil {
+					return nil, err
 				} else {
-					dependencies[dependencyName] = dependencyParams
-					fmt.Fprintf(buff, replaceTextStart)
-					// Enhancement: WriteOut sub tree, to allow alternative content
-					//              for optional includes.
-					fmt.Fprintf(buff, replaceTextEnd)
+					c.addDependency(dependencyName, dependencyParams)
+					buff.WriteString(replaceTextStart)
+					buff.WriteString(alternative)
+					buff.WriteString(replaceTextEnd)
+					continue
+				}
+			}
+			if string(tag) == EsiInclude {
+				if fd, placeholder, err := getEsiInclude(z, tt, attrs); err != nil {
+					return nil, err
+				} else {
+					c.addRequiredContent(fd)
+					buff.WriteString(placeholder)
 					continue
 				}
 			}
@@ -221,16 +251,22 @@ forloop:
 				break forloop
 			}
 		}
-		buff.Write(raw)
+		if err := blocks.write(buff, tt, string(tag), attrs, raw); err != nil {
+			return nil, err
+		}
 	}
+	blocks.closeAll(buff)
 
-	return StringFragment(buff.String()), dependencies, nil
+	return StringFragment(buff.String()), nil
 }
 
-func getInclude(z *html.Tokenizer, attrs []html.Attribute) (startMarker, endMarker, dependencyName string, dependencyParams Params, error error) {
+// getInclude returns the placeholder markers for an uic-include element.
+// The child elements of an optional include are returned as alternative content,
+// which is rendered if the included fragment is not available.
+func getInclude(z *html.Tokenizer, tt html.TokenType, attrs []html.Attribute) (startMarker, alternative, endMarker, dependencyName string, dependencyParams Params, error error) {
 	var srcString string
 	if url, hasUrl := getAttr(attrs, "src"); !hasUrl {
-		return "", "", "", nil, fmt.Errorf("include definition without src %s", z.Raw())
+		return "", "", "", "", nil, fmt.Errorf("include definition without src %s", z.Raw())
 	} else {
 		srcString = strings.TrimSpace(url.Val)
 		if strings.HasPrefix(srcString, "#") {
@@ -250,16 +286,67 @@ func getInclude(z *html.Tokenizer, attrs []html.Attribute) (startMarker, endMark
 	required := false
 	if r, hasRequired := getAttr(attrs, "required"); hasRequired {
 		if requiredBool, err := strconv.ParseBool(r.Val); err != nil {
-			return "", "", "", nil, fmt.Errorf("error parsing bool in %s: %s", z.Raw(), err.Error())
+			return "", "", "", "", nil, fmt.Errorf("error parsing bool in %s: %s", z.Raw(), err.Error())
 		} else {
 			required = requiredBool
 		}
 	}
 
+	if tt == html.StartTagToken {
+		content, err := parseIncludeContent(z)
+		if err != nil {
+			return "", "", "", "", nil, err
+		}
+		alternative = content
+	}
+
 	if required {
-		return fmt.Sprintf("§[> %s]§", srcString), "", dependencyName, dependencyParams, nil
+		// the alternative content is never shown, because a required include fails the whole page
+		startMarker = fmt.Sprintf("§[> %s]§", srcString)
+		alternative = ""
 	} else {
-		return fmt.Sprintf("§[#> %s]§", srcString), fmt.Sprintf("§[/%s]§", srcString), dependencyName, dependencyParams, nil
+		startMarker = fmt.Sprintf("§[#> %s]§", srcString)
+		endMarker = fmt.Sprintf("§[/%s]§", srcString)
+	}
+
+	if condition, hasCondition := getAttr(attrs, UicIf); hasCondition {
+		startMarker = conditionStartMarker(condition.Val) + startMarker
+		endMarker = endMarker + conditionEndMarker
+	}
+	return startMarker, alternative, endMarker, dependencyName, dependencyParams, nil
+}
+
+// parseIncludeContent reads the child elements of an uic-include element up to its end tag.
+// Elements with the uic-if attribute and uic-loop elements are converted into template blocks.
+func parseIncludeContent(z *html.Tokenizer) (string, error) {
+	attrs := make([]html.Attribute, 0, 10)
+	buff := bytes.NewBuffer(nil)
+	blocks := &blockElements{}
+	for {
+		tt := z.Next()
+		tag, _ := z.TagName()
+		raw := byteCopy(z.Raw()) // create a copy here, because readAttributes modifies z.Raw, if attributes contain an &
+		attrs = readAttributes(z, attrs)
+
+		switch {
+		case tt == html.ErrorToken:
+			if z.Err() != io.EOF {
+				return "", z.Err()
+			}
+			return "", fmt.Errorf("include element not closed, missing </%s>", UicInclude)
+		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
+			if skipSubtreeIfUicRemove(z, tt, string(tag), attrs) {
+				continue
+			}
+		case tt == html.EndTagToken && string(tag) == UicInclude:
+			blocks.closeAll(buff)
+			return strings.TrimSpace(buff.String()), nil
+		case tt == html.EndTagToken && blocks.depthOf(string(tag)) == -1:
+			return "", fmt.Errorf("include element not closed, found %s instead of </%s>", raw, UicInclude)
+		}
+		if err := blocks.write(buff, tt, string(tag), attrs, raw); err != nil {
+			return "", err
+		}
 	}
 }
 
@@ -302,9 +389,27 @@ func getFetch(z *html.Tokenizer, attrs []html.Attribute) (*FetchDefinition, erro
 	return fd, nil
 }
 
+// ParseHeadFragment removes the meta and title tags and the resources from the head fragment,
+// which are already contained in the headPropertyMap, and adds the remaining ones to it.
+// Resources are link elements, script elements with a src and style elements, see removeDuplicateResources.
+//
+// Deprecated: The heads are merged by the HeadModel of the ContentMerge.
 func ParseHeadFragment(fragment *StringFragment, headPropertyMap map[string]string) error {
+	return filterHeadFragment(fragment, headPropertyMap, true)
+}
+
+// removeDuplicateResources removes the link, script and style elements from the fragment,
+// which are already contained in the resourceMap, and adds the remaining ones to it.
+// Link and script elements are matched by their normalized url and style elements by the hash of their content.
+// Elements within template blocks, e.g. of uic-if, are kept, because they may not be rendered.
+func removeDuplicateResources(fragment *StringFragment, resourceMap map[string]string) error {
+	return filterHeadFragment(fragment, resourceMap, false)
+}
+
+func filterHeadFragment(fragment *StringFragment, headPropertyMap map[string]string, filterMetaAndTitle bool) error {
 	attrs := make([]html.Attribute, 0, 10)
 	headBuff := bytes.NewBuffer(nil)
+	blockDepth := 0
 	z := html.NewTokenizer(strings.NewReader(string(*fragment)))
 forloop:
 	for {
@@ -321,13 +426,13 @@ forloop:
 			break forloop
 		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
 
-			if string(tag) == "meta" {
+			if filterMetaAndTitle && string(tag) == "meta" {
 				if processMetaTag(string(tag), attrs, headPropertyMap) {
 					headBuff.Write(raw)
 				}
 				continue
 			}
-			if string(tag) == "title" {
+			if filterMetaAndTitle && string(tag) == "title" {
 				if headPropertyMap["title"] == "" {
 					headPropertyMap["title"] = "title"
 					headBuff.Write(raw)
@@ -335,20 +440,23 @@ forloop:
 					skipCompleteTag(z, "title")
 					continue
 				}
+			} else if isResourceElement(string(tag), attrs) && blockDepth == 0 {
+				if err := processResource(z, tt, string(tag), attrs, raw, headBuff, headPropertyMap); err != nil {
+					return err
+				}
 			} else {
 				headBuff.Write(raw)
 			}
+		case tt == html.TextToken:
+			blockDepth += templateBlockDepth(string(raw))
+			headBuff.Write(raw)
 		default:
 			headBuff.Write(raw)
 		}
 
 	}
 
-	s := headBuff.String()
-
-	if len(s) > 0 {
-		*fragment = StringFragment(s)
-	}
+	*fragment = StringFragment(headBuff.String())
 	return nil
 }
 
@@ -422,9 +530,163 @@ func parseMetaJson(z *html.Tokenizer, c *MemoryContent) error {
 	return nil
 }
 
+// conditionEndMarker ends the conditional block of an element with the uic-if attribute
+var conditionEndMarker = PlaceholderStart + EndCondition + PlaceholderEnd
+
+// loopEndMarker ends the loop block of an uic-loop element
+var loopEndMarker = PlaceholderStart + EndLoop + PlaceholderEnd
+
+func conditionStartMarker(condition string) string {
+	return PlaceholderStart + StartCondition + strings.TrimSpace(condition) + PlaceholderEnd
+}
+
+func loopStartMarker(attrs []html.Attribute) (string, error) {
+	items, hasItems := getAttr(attrs, "items")
+	if !hasItems || strings.TrimSpace(items.Val) == "" {
+		return "", fmt.Errorf("%s element without items attribute", UicLoop)
+	}
+	definition := strings.TrimSpace(items.Val)
+	if as, hasAs := getAttr(attrs, "as"); hasAs {
+		definition += " as " + strings.TrimSpace(as.Val)
+		if index, hasIndex := getAttr(attrs, "index"); hasIndex {
+			definition += ", " + strings.TrimSpace(index.Val)
+		}
+	} else if index, hasIndex := getAttr(attrs, "index"); hasIndex {
+		definition += " as item, " + strings.TrimSpace(index.Val)
+	}
+	return PlaceholderStart + StartLoop + definition + PlaceholderEnd, nil
+}
+
+// blockElements converts elements with the uic-if attribute and uic-loop elements into template blocks.
+// It tracks the open elements, to find the end of these elements. Like in html, an end tag closes
+// also the elements opened after its start tag, e.g. list items without end tag,
+// and an end tag without a matching start tag is ignored.
+type blockElements struct {
+	elements []string // the names of the open elements
+	open     []openBlock
+}
+
+type openBlock struct {
+	depth      int    // the index of the element in the open elements
+	hideEndTag bool   // the end tag of uic-loop is not written
+	endMarkers string // the end markers of the blocks, written after the end tag
+}
+
+// impliedEndTagElements are closed by the start tag of a sibling with the same name
+var impliedEndTagElements = map[string]bool{
+	"dd":     true,
+	"dt":     true,
+	"li":     true,
+	"option": true,
+	"p":      true,
+	"td":     true,
+	"th":     true,
+	"tr":     true,
+}
+
+// write writes a token. A start tag with the uic-if attribute is written without this attribute,
+// after the start of a conditional block, which is ended after its end tag.
+// The tags of uic-loop elements are replaced by the start and the end of a loop block.
+func (be *blockElements) write(buff *bytes.Buffer, tt html.TokenType, tagName string, attrs []html.Attribute, raw []byte) error {
+	switch {
+	case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
+		if last := len(be.elements) - 1; last >= 0 && impliedEndTagElements[tagName] && be.elements[last] == tagName {
+			be.closeElements(buff, last)
+		}
+
+		block := openBlock{depth: len(be.elements)}
+		condition, hasCondition := getAttr(attrs, UicIf)
+		if hasCondition {
+			buff.WriteString(conditionStartMarker(condition.Val))
+			block.endMarkers = conditionEndMarker
+		}
+
+		if tagName == UicLoop {
+			marker, err := loopStartMarker(attrs)
+			if err != nil {
+				return err
+			}
+			buff.WriteString(marker)
+			block.hideEndTag = true
+			block.endMarkers = loopEndMarker + block.endMarkers
+		} else if !hasCondition {
+			buff.Write(raw)
+		} else {
+			buff.WriteString("<" + tagName)
+			if otherAttrs := removeAttr(attrs, UicIf); len(otherAttrs) > 0 {
+				buff.WriteString(" " + joinAttrs(otherAttrs))
+			}
+			if tt == html.SelfClosingTagToken {
+				buff.WriteString("/")
+			}
+			buff.WriteString(">")
+		}
+
+		if isSelfClosingTag(tagName, tt) {
+			buff.WriteString(block.endMarkers)
+			return nil
+		}
+		if block.endMarkers != "" {
+			be.open = append(be.open, block)
+		}
+		be.elements = append(be.elements, tagName)
+
+	case tt == html.EndTagToken:
+		depth := be.depthOf(tagName)
+		if depth == -1 {
+			buff.Write(raw)
+			return nil
+		}
+		be.closeElements(buff, depth+1)
+		last := len(be.open) - 1
+		if last < 0 || be.open[last].depth < depth {
+			buff.Write(raw)
+		} else {
+			if !be.open[last].hideEndTag {
+				buff.Write(raw)
+			}
+			buff.WriteString(be.open[last].endMarkers)
+			be.open = be.open[:last]
+		}
+		be.elements = be.elements[:depth]
+
+	default:
+		buff.Write(raw)
+	}
+	return nil
+}
+
+// depthOf returns the index of the innermost open element with the name, or -1 if there is none.
+func (be *blockElements) depthOf(tagName string) int {
+	for i := len(be.elements) - 1; i >= 0; i-- {
+		if be.elements[i] == tagName {
+			return i
+		}
+	}
+	return -1
+}
+
+// closeElements closes the open elements from the depth on, which have no end tag,
+// by ending their blocks.
+func (be *blockElements) closeElements(buff *bytes.Buffer, depth int) {
+	for len(be.open) > 0 && be.open[len(be.open)-1].depth >= depth {
+		buff.WriteString(be.open[len(be.open)-1].endMarkers)
+		be.open = be.open[:len(be.open)-1]
+	}
+	if depth < len(be.elements) {
+		be.elements = be.elements[:depth]
+	}
+}
+
+// closeAll ends the blocks of elements, which were not closed in the html.
+func (be *blockElements) closeAll(buff *bytes.Buffer) {
+	be.closeElements(buff, 0)
+}
+
+// skipSubtreeIfUicRemove skips elements with the uic-remove attribute and esi:remove elements
 func skipSubtreeIfUicRemove(z *html.Tokenizer, tt html.TokenType, tagName string, attrs []html.Attribute) bool {
 	_, foundRemoveTag := getAttr(attrs, UicRemove)
-	if !foundRemoveTag {
+	if !foundRemoveTag && tagName != EsiRemove {
 		return false
 	}
 
@@ -460,6 +722,17 @@ func getAttr(attrs []html.Attribute, name string) (attr html.Attribute, found bo
 	return html.Attribute{}, false
 }
 
+// removeAttr returns a copy of the attributes without the named attribute
+func removeAttr(attrs []html.Attribute, name string) []html.Attribute {
+	result := make([]html.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key != name {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
 // getFragmentName returns the name attribute, or "" if none was given
 func getFragmentName(attrs []html.Attribute) string {
 	for _, a := range attrs {
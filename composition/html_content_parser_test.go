@@ -298,6 +298,7 @@ func Test_HtmlContentParser_LoadEmptyContent(t *testing.T) {
 	a.Equal(0, len(c.Meta()))
 	a.Equal(0, len(c.RequiredContent()))
 	a.Nil(c.Head())
+	a.Nil(c.HtmlAttributes())
 	a.Nil(c.Tail())
 }
 
@@ -465,6 +466,143 @@ func Test_HtmlContentParser_fetchAndInclude_ErrorCases(t *testing.T) {
 	}
 }
 
+func Test_HtmlContentParser_parseBody_AlternativeIncludeContent(t *testing.T) {
+	a := assert.New(t)
+
+	parser := &HtmlContentParser{}
+	z := html.NewTokenizer(bytes.NewBufferString(`<body>
+    <uic-include src="example.com/optional#content">
+      <div class="placeholder"><p>some <b>alternative</b> text</p><br></div>
+      <span uic-remove>removed</span>
+    </uic-include>
+    <uic-include src="example.com/required#content" required="true">
+      <p>never shown</p>
+    </uic-include>
+    <uic-fragment name="headline">
+      <uic-include src="example.com/optional#headline"><h1>Default Headline</h1></uic-include>
+    </uic-fragment>
+  </body>`))
+
+	z.Next() // At <body ..
+	c := NewMemoryContent()
+	err := parser.parseBody(z, c)
+	a.NoError(err)
+
+	eqFragment(t, `§[#> example.com/optional#content]§`+
+		`<div class="placeholder"><p>some <b>alternative</b> text</p><br></div>`+
+		`§[/example.com/optional#content]§`+
+		`§[> example.com/required#content]§`, c.Body()[""])
+	eqFragment(t, `§[#> example.com/optional#headline]§<h1>Default Headline</h1>§[/example.com/optional#headline]§`, c.Body()["headline"])
+}
+
+func Test_HtmlContentParser_parseBody_AlternativeIncludeContentNotClosed(t *testing.T) {
+	a := assert.New(t)
+
+	testCases := []string{
+		`<uic-fragment><uic-include src="example.com/foo"><p>alternative</p></uic-fragment>`,
+		`<uic-include src="example.com/foo"><p>alternative</p>`,
+	}
+
+	for _, test := range testCases {
+		parser := &HtmlContentParser{}
+		z := html.NewTokenizer(bytes.NewBufferString("<body>" + test + "</body>"))
+		z.Next() // At <body ..
+		err := parser.parseBody(z, NewMemoryContent())
+		a.Error(err, test)
+	}
+}
+
+func Test_HtmlContentParser_UicIf(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html>
+  <head>
+    <link uic-if="feature.newHeader" rel="stylesheet" href="new-header.css"/>
+  </head>
+  <body>
+    <div uic-if="feature.newHeader" class="header"><div>new</div><br></div>
+    <div uic-if="!feature.newHeader">old</div>
+    <uic-fragment name="teaser">
+      <ul uic-if="teasers"><li uic-if="teasers.first">first</li></ul>
+      <uic-include uic-if="variant == b" src="example.com/teaser#b"/>
+    </uic-fragment>
+  </body>
+</html>`))
+	a.NoError(err)
+
+	eqFragment(t, `§[?feature.newHeader]§<link rel="stylesheet" href="new-header.css"/>§[/?]§`, c.Head())
+	eqFragment(t, `§[?feature.newHeader]§<div class="header"><div>new</div><br></div>§[/?]§`+
+		`§[?!feature.newHeader]§<div>old</div>§[/?]§`, c.Body()[""])
+	eqFragment(t, `§[?teasers]§<ul>§[?teasers.first]§<li>first</li>§[/?]§</ul>§[/?]§`+
+		`§[?variant == b]§§[#> example.com/teaser#b]§§[/example.com/teaser#b]§§[/?]§`, c.Body()["teaser"])
+}
+
+func Test_HtmlContentParser_HtmlAttributes(t *testing.T) {
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<!DOCTYPE html><html lang="de" class="no-js" data-x="a&amp;b"><body>text</body></html>`))
+	assert.NoError(t, err)
+	eqFragment(t, `lang="de" class="no-js" data-x="a&amp;b"`, c.HtmlAttributes())
+}
+
+func Test_HtmlContentParser_UicIfNotClosed(t *testing.T) {
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html><body><div uic-if="foo"><p>text</body></html>`))
+	assert.NoError(t, err)
+	eqFragment(t, `§[?foo]§<div><p>text§[/?]§`, c.Body()[""])
+}
+
+func Test_HtmlContentParser_UicIfWithOptionalEndTags(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html><body>`+
+		`<ul uic-if="list"><li>a<li>b</ul><p>after</p>`+
+		`<ul><li uic-if="first">first<li>second</ul>`+
+		`<uic-include src="example.com/foo"><ol uic-if="list"><li>alternative</ol></uic-include>`+
+		`</body></html>`))
+	a.NoError(err)
+
+	eqFragment(t, `§[?list]§<ul><li>a<li>b</ul>§[/?]§<p>after</p>`+
+		`<ul>§[?first]§<li>first§[/?]§<li>second</ul>`+
+		`§[#> example.com/foo]§§[?list]§<ol><li>alternative</ol>§[/?]§§[/example.com/foo]§`, c.Body()[""])
+}
+
+func Test_HtmlContentParser_UicLoop(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html>
+  <body>
+    <ul>
+      <uic-loop items="categories" as="category" index="i"><li data-index="§[i]§">§[category]§</li></uic-loop>
+    </ul>
+    <uic-fragment name="teasers">
+      <uic-loop items="teasers" uic-if="feature.teasers"><div><h2>§[item.title]§</h2></div></uic-loop>
+      <uic-loop items="tags" index="i"><uic-include src="example.com/tag#content"/></uic-loop>
+    </uic-fragment>
+  </body>
+</html>`))
+	a.NoError(err)
+
+	eqFragment(t, `<ul>§[*categories as category, i]§<li data-index="§[i]§">§[category]§</li>§[/*]§</ul>`, c.Body()[""])
+	eqFragment(t, `§[?feature.teasers]§§[*teasers]§<div><h2>§[item.title]§</h2></div>§[/*]§§[/?]§`+
+		`§[*tags as item, i]§§[#> example.com/tag#content]§§[/example.com/tag#content]§§[/*]§`, c.Body()["teasers"])
+
+	cm := NewContentMerge(map[string]interface{}{"categories": []interface{}{"animal", "human"}})
+	c.name = LayoutFragmentName
+	cm.AddContent(c, 0)
+	html, err := cm.GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), `<li data-index="0">animal</li><li data-index="1">human</li>`)
+}
+
+func Test_HtmlContentParser_UicLoopWithoutItems(t *testing.T) {
+	c := NewMemoryContent()
+	err := (&HtmlContentParser{}).Parse(c, bytes.NewBufferString(`<html><body><uic-loop as="x">§[x]§</uic-loop></body></html>`))
+	assert.Error(t, err)
+}
+
 func Test_HtmlContentParser_parseBody_OnlyDefaultFragment(t *testing.T) {
 	a := assert.New(t)
 
@@ -537,7 +675,7 @@ func Test_HtmlContentParser_parseFragment(t *testing.T) {
     </uic-fragment><testend>`))
 
 	z.Next() // At <uic-fragment name ..
-	f, _, err := parseFragment(z)
+	f, err := parseFragment(z, NewMemoryContent())
 	a.NoError(err)
 
 	sFragment := f.(StringFragment)
@@ -863,6 +1001,37 @@ func Test_ParseHeadFragment_Filter_Meta_Tag(t *testing.T) {
 	a.Equal(expectedParsedHead, resultString)
 }
 
+func Test_ParseHeadFragment_Filter_Resources(t *testing.T) {
+	a := assert.New(t)
+
+	originalHeadString := `<link rel="stylesheet" href="https://EXAMPLE.com:443/shared/./base.css#v1">
+	<link rel="stylesheet" href="/shared/print.css" media="print">
+	<link rel="stylesheet" href="/shared/print.css">
+	<script src="/js/jquery.js"></script>
+	<script>init()</script>
+	<style> p { color: red } </style>
+	<style media="print">p { color: red }</style>
+	§[?legacy]§<script src="/js/legacy.js"></script>§[/?]§`
+
+	expectedParsedHead := `<link rel="stylesheet" href="/shared/print.css">
+	<script>init()</script>
+	<style media="print">p { color: red }</style>
+	§[?legacy]§<script src="/js/legacy.js"></script>§[/?]§`
+
+	headPropertyMap := make(map[string]string)
+	previousHead := StringFragment(`<link rel="stylesheet" href="https://example.com/shared/base.css">
+	<link rel="stylesheet" href="/shared/print.css" media="print">
+	<script src="/js/jquery.js"></script>
+	<style>p { color: red }</style>
+	<script src="/js/legacy.js"></script>`)
+	ParseHeadFragment(&previousHead, headPropertyMap)
+
+	headFragment := StringFragment(originalHeadString)
+	ParseHeadFragment(&headFragment, headPropertyMap)
+
+	a.Equal(removeTabsAndNewLines(expectedParsedHead), removeTabsAndNewLines(string(headFragment)))
+}
+
 func removeTabsAndNewLines(stringToProcess string) string {
 	stringToProcess = strings.Replace(stringToProcess, "\n", "", -1)
 	stringToProcess = strings.Replace(stringToProcess, "\t", "", -1)
@@ -1,10 +1,14 @@
 package composition
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tarent/lib-compose/logging"
+	"github.com/tarent/lib-compose/metrics"
+	"github.com/tarent/lib-compose/tracing"
 	"github.com/tarent/lib-servicediscovery/servicediscovery"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -14,35 +18,56 @@ import (
 )
 
 var redirectAttemptedError = errors.New("do not follow redirects")
-var noRedirectFunc = func(req *http.Request, via []*http.Request) error {
-	return redirectAttemptedError
-}
 
 type HttpContentLoader struct {
-	parser map[string]ContentParser
+	parsers        *ContentParsers
+	circuitBreaker *CircuitBreaker
+	transport      *Transport
 }
 
+// NewHttpContentLoader creates a loader, which uses the DefaultTransport.
 func NewHttpContentLoader() *HttpContentLoader {
+	return NewHttpContentLoaderWithTransport(DefaultTransport)
+}
+
+// NewHttpContentLoaderWithTransport creates a loader, which uses the connection pool of the transport.
+func NewHttpContentLoaderWithTransport(transport *Transport) *HttpContentLoader {
 	return &HttpContentLoader{
-		parser: map[string]ContentParser{
-			"text/html": &HtmlContentParser{},
-		},
+		parsers:        DefaultContentParsers.Copy(),
+		circuitBreaker: DefaultCircuitBreaker,
+		transport:      transport,
 	}
 }
 
+// WithCircuitBreaker sets a circuit breaker, which stops the requests to failing backends.
+// The circuits are keyed by the host or service name of the urls.
+func (loader *HttpContentLoader) WithCircuitBreaker(circuitBreaker *CircuitBreaker) *HttpContentLoader {
+	loader.circuitBreaker = circuitBreaker
+	return loader
+}
+
 // TODO: Should we filter the headers, which we forward here, or is it correct to copy all of them?
-func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
-	client := &http.Client{Timeout: fd.Timeout}
+func (loader *HttpContentLoader) Load(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	ctx, span := tracing.Default.Start(ctx, "HttpContentLoader.Load")
+	defer span.End()
+	span.SetAttribute("url", fd.URL)
+	span.SetAttribute("method", fd.Method)
 
+	c, err := loader.load(ctx, fd)
+	if c != nil {
+		span.SetAttribute("status", c.HttpStatusCode())
+	}
+	if err != nil {
+		span.SetError(err)
+	}
+	return c, err
+}
+
+func (loader *HttpContentLoader) load(ctx context.Context, fd *FetchDefinition) (*MemoryContent, error) {
 	c := NewMemoryContent()
 	c.name = fd.Name
 	c.httpStatusCode = 502
 
-	// redirects can only be stopped by returning an error in the CheckRedirect function
-	if !fd.FollowRedirects {
-		client.CheckRedirect = noRedirectFunc
-	}
-
 	fetchUrl := fd.URL
 	if fd.ServiceDiscoveryActive {
 		discoveredUrl, err := loader.discoverServiceInUrl(fetchUrl, fd.ServiceDiscovery)
@@ -56,15 +81,22 @@ func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
 	if err != nil {
 		return c, err
 	}
-	request.Header = fd.Header
-	if request.Header == nil {
-		request.Header = http.Header{}
+
+	// the timeout covers all attempts and the reading of the body,
+	// so it is canceled, when the body is closed
+	requestCtx, cancel := context.WithCancel(ctx)
+	if fd.Timeout > 0 {
+		requestCtx, cancel = context.WithTimeout(ctx, fd.Timeout)
+	}
+	request = request.WithContext(requestCtx)
+	request.Header = http.Header{}
+	for k, v := range fd.Header {
+		request.Header[k] = v
 	}
 	request.Header.Set("User-Agent", "lib-compose")
+	tracing.Inject(ctx, request.Header)
 
-	start := time.Now()
-
-	resp, err := client.Do(request)
+	resp, err := loader.do(ctx, request, fd)
 	if resp != nil {
 		c.httpStatusCode = resp.StatusCode
 		c.httpHeader = resp.Header
@@ -72,46 +104,53 @@ func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
 
 	// do not handle our own redirects returns as errors
 	if urlError, ok := err.(*url.Error); ok && urlError.Err == redirectAttemptedError {
-		logging.Call(request, resp, start, nil)
+		cancel()
 		return c, nil
 	}
-	logging.Call(request, resp, start, err)
 
 	if err != nil {
+		cancel()
 		return c, err
 	}
+	resp.Body = &cancelingReadCloser{ReadCloser: resp.Body, cancel: cancel}
 
 	if fd.RespProc != nil {
 		if err := fd.RespProc.Process(resp, fd.URL); err != nil {
+			resp.Body.Close()
 			return c, err
 		}
 	}
 
 	if c.httpStatusCode < 200 || c.httpStatusCode > 399 {
+		// read and close the body, to make reuse of tcp connections
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		return c, fmt.Errorf("(http %v) on loading url %q", c.httpStatusCode, fd.URL)
 	}
 
-	// take the first parser for the content type
-	// direct access to the map does not work, because the
-	// content type may have encoding information at the end
 	reponseType := resp.Header.Get("Content-Type")
 	responseNoCompositionHeader := resp.Header.Get("X-No-Composition")
 	if responseNoCompositionHeader == "" {
-		for contentType, parser := range loader.parser {
-			if strings.HasPrefix(reponseType, contentType) {
-				defer func() {
-					// read and close the body, to make reuse of tcp connections
-					ioutil.ReadAll(resp.Body)
-					resp.Body.Close()
-				}()
-				parsingStart := time.Now()
-				err := parser.Parse(c, resp.Body)
-				logging.Logger.
-					WithField("full_url", fd.URL).
-					WithField("duration", time.Since(parsingStart)).
-					Debug("content parsing")
-				return c, err
+		if parser, found := loader.parsers.Lookup(reponseType); found {
+			defer func() {
+				// read and close the body, to make reuse of tcp connections
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}()
+			parsingStart := time.Now()
+			_, span := tracing.Default.Start(ctx, "ContentParser.Parse")
+			span.SetAttribute("content_type", reponseType)
+			err := parser.Parse(c, resp.Body)
+			if err != nil {
+				span.SetError(err)
 			}
+			span.End()
+			metrics.Default.Parse(time.Since(parsingStart))
+			logging.Logger.
+				WithField("full_url", fd.URL).
+				WithField("duration", time.Since(parsingStart)).
+				Debug("content parsing")
+			return c, err
 		}
 	}
 
@@ -119,6 +158,125 @@ func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
 	return c, nil
 }
 
+// RegisterParser sets the parser for responses of the content type.
+// See ContentParsers.Register for the matching of content types.
+func (loader *HttpContentLoader) RegisterParser(contentType string, parser ContentParser) *HttpContentLoader {
+	if loader.parsers == nil {
+		loader.parsers = NewContentParsers()
+	}
+	loader.parsers.Register(contentType, parser)
+	return loader
+}
+
+// do sends the request and repeats it, as defined by the retry policy of the fetch definition.
+// All attempts together are limited by the timeout of the fetch definition.
+func (loader *HttpContentLoader) do(ctx context.Context, request *http.Request, fd *FetchDefinition) (*http.Response, error) {
+	backend := backendName(fd.URL)
+	policy := fd.RetryPolicy
+	if policy == nil || !policy.appliesTo(fd) {
+		return loader.attempt(ctx, request, fd, backend)
+	}
+
+	deadline, hasDeadline := request.Context().Deadline()
+	for attempt := 1; ; attempt++ {
+		resp, err := loader.attempt(ctx, request, fd, backend)
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.isRetryable(resp, err) {
+			return resp, err
+		}
+
+		backoff := policy.backoff(attempt)
+		if hasDeadline && deadline.Sub(time.Now()) <= backoff {
+			return resp, err
+		}
+		if resp != nil {
+			// read and close the body, to make reuse of tcp connections
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+
+		logging.Logger.WithError(err).
+			WithField("full_url", fd.URL).
+			Infof("retrying %v after attempt %v in %v", fd.URL, attempt, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attempt sends the request once, if the circuit breaker allows it.
+func (loader *HttpContentLoader) attempt(ctx context.Context, request *http.Request, fd *FetchDefinition, backend string) (*http.Response, error) {
+	var ticket CircuitTicket
+	if loader.circuitBreaker != nil {
+		var err error
+		if ticket, err = loader.circuitBreaker.Allow(backend); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	transport := loader.transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	resp, err := transport.do(request, fd.FollowRedirects)
+	loader.recordCircuitResult(ctx, ticket, resp, err)
+	if resp != nil {
+		metrics.Default.Fetch(backend, resp.StatusCode, time.Since(start))
+	} else {
+		metrics.Default.Fetch(backend, 0, time.Since(start))
+	}
+
+	// do not handle our own redirects returns as errors
+	if urlError, ok := err.(*url.Error); ok && urlError.Err == redirectAttemptedError {
+		logging.Call(request, resp, start, nil)
+	} else {
+		logging.Call(request, resp, start, err)
+	}
+	return resp, err
+}
+
+// recordCircuitResult records a response with status >= 500 or an error as failure,
+// unless the request was canceled by the caller.
+func (loader *HttpContentLoader) recordCircuitResult(ctx context.Context, ticket CircuitTicket, resp *http.Response, err error) {
+	if loader.circuitBreaker == nil {
+		return
+	}
+	if urlError, ok := err.(*url.Error); ok && urlError.Err == redirectAttemptedError {
+		loader.circuitBreaker.Done(ticket, false)
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		loader.circuitBreaker.Canceled(ticket)
+		return
+	}
+	loader.circuitBreaker.Done(ticket, err != nil || resp.StatusCode >= 500)
+}
+
+// cancelingReadCloser releases the context of the request, when the body is closed.
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (rc *cancelingReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.cancel()
+	return err
+}
+
+// backendName returns the host of the url, by which the metrics of the backend are labeled.
+func backendName(rawUrl string) string {
+	if parsedUrl, err := url.Parse(rawUrl); err == nil && parsedUrl.Host != "" {
+		return parsedUrl.Host
+	}
+	if strings.HasPrefix(rawUrl, FileURLPrefix) {
+		return "file"
+	}
+	return "unknown"
+}
+
 func (loader *HttpContentLoader) discoverServiceInUrl(rawUrl string, serviceDiscovery servicediscovery.ServiceDiscovery) (string, error) {
 
 	parsedUrl, err := url.Parse(rawUrl)
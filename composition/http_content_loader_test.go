@@ -1,9 +1,12 @@
 package composition
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/metrics"
+	"github.com/tarent/lib-compose/tracing"
 	"github.com/tarent/lib-servicediscovery/servicediscovery"
 	"io"
 	"io/ioutil"
@@ -33,11 +36,11 @@ func Test_HttpContentLoader_Load(t *testing.T) {
 			c.head = StringFragment("some head content")
 		})
 
-	loader.parser["text/html"] = mockParser
+	loader.RegisterParser("text/html", mockParser)
 
 	fd := NewFetchDefinition(server.URL)
 	fd.Name = "content"
-	c, err := loader.Load(fd)
+	c, err := loader.Load(context.Background(), fd)
 	a.NoError(err)
 	a.NotNil(c)
 	a.Nil(c.Reader())
@@ -67,11 +70,11 @@ func Test_HttpContentLoader_Load_ResponseProcessor(t *testing.T) {
 			c.head = StringFragment("some head content")
 		})
 
-	loader.parser["text/html"] = mockParser
+	loader.RegisterParser("text/html", mockParser)
 
 	mockResponseProcessor := NewMockResponseProcessor(ctrl)
 	mockResponseProcessor.EXPECT().Process(gomock.Any(), gomock.Any())
-	c, err := loader.Load(NewFetchDefinition(server.URL).WithResponseProcessor(mockResponseProcessor).FromRequest(request))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL).WithResponseProcessor(mockResponseProcessor).FromRequest(request))
 	a.NoError(err)
 	a.NotNil(c)
 	a.Nil(c.Reader())
@@ -104,14 +107,14 @@ func Test_HttpContentLoader_Load_POST(t *testing.T) {
 			c.head = StringFragment("some head content")
 		})
 
-	loader.parser["text/html"] = mockParser
+	loader.RegisterParser("text/html", mockParser)
 
 	fd := NewFetchDefinition(server.URL)
 	fd.Header = http.Header{"X-Foo": {"bar"}}
 	fd.Method = "POST"
 	fd.Body = strings.NewReader("post content")
 
-	c, err := loader.Load(fd)
+	c, err := loader.Load(context.Background(), fd)
 	a.NoError(err)
 	a.NotNil(c)
 	a.Nil(c.Reader())
@@ -120,6 +123,33 @@ func Test_HttpContentLoader_Load_POST(t *testing.T) {
 	a.Equal(0, len(c.Body()))
 }
 
+func Test_HttpContentLoader_RegisterParser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.example+json; charset=utf-8")
+		w.Write([]byte(`{"some": "json"}`))
+	}))
+	defer server.Close()
+
+	mockParser := NewMockContentParser(ctrl)
+	mockParser.EXPECT().Parse(gomock.Any(), gomock.Any()).
+		Do(func(c *MemoryContent, in io.Reader) {
+			c.head = StringFragment("some head content")
+		})
+
+	loader := NewHttpContentLoader().
+		RegisterParser("application/", NewMockContentParser(ctrl)).
+		RegisterParser("application/vnd.example+json", mockParser)
+
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
+	a.NoError(err)
+	a.Nil(c.Reader())
+	eqFragment(t, "some head content", c.Head())
+}
+
 func Test_HttpContentLoader_LoadStream(t *testing.T) {
 	a := assert.New(t)
 
@@ -130,7 +160,7 @@ func Test_HttpContentLoader_LoadStream(t *testing.T) {
 	defer server.Close()
 
 	loader := &HttpContentLoader{}
-	c, err := loader.Load(NewFetchDefinition(server.URL))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
 	a.NoError(err)
 	a.NotNil(c.Reader())
 	body, err := ioutil.ReadAll(c.Reader())
@@ -149,7 +179,7 @@ func Test_HttpContentLoader_LoadStream_No_Composition_Header(t *testing.T) {
 	defer server.Close()
 
 	loader := &HttpContentLoader{}
-	c, err := loader.Load(NewFetchDefinition(server.URL))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
 	a.NoError(err)
 	a.NotNil(c.Reader())
 	body, err := ioutil.ReadAll(c.Reader())
@@ -168,7 +198,7 @@ func Test_HttpContentLoader_Pass_404(t *testing.T) {
 	defer server.Close()
 
 	loader := &HttpContentLoader{}
-	c, err := loader.Load(NewFetchDefinition(server.URL))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
 	a.Error(err)
 	a.Equal(404, c.HttpStatusCode())
 }
@@ -182,17 +212,33 @@ func Test_HttpContentLoader_LoadError500(t *testing.T) {
 	defer server.Close()
 
 	loader := &HttpContentLoader{}
-	c, err := loader.Load(NewFetchDefinition(server.URL))
+	c, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
 	a.Error(err)
 	a.Contains(err.Error(), "http 500")
 	assert.True(t, c.HttpStatusCode() == 500)
 }
 
+func Test_HttpContentLoader_LoadCanceledByContext(t *testing.T) {
+	a := assert.New(t)
+
+	server := testServer("some content", time.Second)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	loader := NewHttpContentLoader()
+	start := time.Now()
+	_, err := loader.Load(ctx, NewFetchDefinition(server.URL))
+	a.Error(err)
+	a.True(time.Since(start) < time.Millisecond*500)
+}
+
 func Test_HttpContentLoader_LoadErrorNetwork(t *testing.T) {
 	a := assert.New(t)
 
 	loader := &HttpContentLoader{}
-	_, err := loader.Load(NewFetchDefinition("..."))
+	_, err := loader.Load(context.Background(), NewFetchDefinition("..."))
 	a.Error(err)
 	a.Contains(err.Error(), "unsupported protocol scheme")
 }
@@ -212,7 +258,7 @@ func Test_HttpContentLoader_FollowRedirects(t *testing.T) {
 		loader := &HttpContentLoader{}
 		fd := NewFetchDefinition(server.URL)
 		fd.FollowRedirects = true
-		c, err := loader.Load(fd)
+		c, err := loader.Load(context.Background(), fd)
 		a.NoError(err)
 		a.Equal(200, c.HttpStatusCode())
 
@@ -240,7 +286,7 @@ func Test_HttpContentLoader_DoNotFollowRedirects(t *testing.T) {
 		loader := &HttpContentLoader{}
 		fd := NewFetchDefinition(server.URL)
 		fd.FollowRedirects = false
-		c, err := loader.Load(fd)
+		c, err := loader.Load(context.Background(), fd)
 		a.NoError(err)
 
 		a.Equal(status, c.HttpStatusCode())
@@ -329,3 +375,67 @@ func testServer(content string, timeout time.Duration) *httptest.Server {
 		w.Write([]byte(content))
 	}))
 }
+
+func Test_HttpContentLoader_RecordsMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recorder for the metrics
+	recorder := metrics.NewPrometheusRecorder()
+	metrics.Default = recorder
+	defer func() {
+		metrics.Default = metrics.NopRecorder{}
+	}()
+
+	server := testServer("<html><body>the body</body></html>", time.Millisecond*0)
+	defer server.Close()
+	backend := strings.TrimPrefix(server.URL, "http://")
+
+	// when a content is loaded
+	_, err := NewHttpContentLoader().Load(context.Background(), NewFetchDefinition(server.URL))
+	a.NoError(err)
+
+	// then the fetch and the parsing are recorded
+	text := string(recorder.Text())
+	a.Contains(text, `lib_compose_fetch_duration_seconds_count{backend="`+backend+`"} 1`)
+	a.Contains(text, `lib_compose_fetch_responses_total{backend="`+backend+`",status="200"} 1`)
+	a.Contains(text, "lib_compose_parse_duration_seconds_count 1")
+}
+
+func Test_HttpContentLoader_Tracing(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recording tracer
+	exporter := tracing.NewInMemoryExporter()
+	tracing.Default = tracing.NewRecordingTracer(exporter)
+	defer func() {
+		tracing.Default = tracing.NopTracer{}
+	}()
+
+	// and a server, which records the traceparent
+	traceParent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>the body</body></html>"))
+	}))
+	defer server.Close()
+
+	// when a content is loaded
+	fd := NewFetchDefinition(server.URL)
+	_, err := NewHttpContentLoader().Load(context.Background(), fd)
+	a.NoError(err)
+
+	// then the spans of the loading and the parsing are recorded
+	loadSpans := exporter.SpansByName("HttpContentLoader.Load")
+	parseSpans := exporter.SpansByName("ContentParser.Parse")
+	a.Equal(1, len(loadSpans))
+	a.Equal(1, len(parseSpans))
+	a.Equal(200, loadSpans[0].Attributes["status"])
+	a.Equal(loadSpans[0].SpanContext.SpanID, parseSpans[0].ParentSpanID)
+
+	// and the backend got the span of the loading as parent
+	a.Equal(loadSpans[0].SpanContext.TraceParent(), traceParent)
+
+	// and the fetch definition is unchanged
+	a.Equal("", fd.Header.Get("traceparent"))
+}
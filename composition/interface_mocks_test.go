@@ -4,7 +4,9 @@
 package composition
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
+	cache "github.com/tarent/lib-compose/cache"
 	
 	io "io"
 	http "net/http"
@@ -72,15 +74,15 @@ func (_m *MockContentLoader) EXPECT() *_MockContentLoaderRecorder {
 	return _m.recorder
 }
 
-func (_m *MockContentLoader) Load(_param0 *FetchDefinition) (Content, error) {
-	ret := _m.ctrl.Call(_m, "Load", _param0)
+func (_m *MockContentLoader) Load(_param0 context.Context, _param1 *FetchDefinition) (Content, error) {
+	ret := _m.ctrl.Call(_m, "Load", _param0, _param1)
 	ret0, _ := ret[0].(Content)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockContentLoaderRecorder) Load(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Load", arg0)
+func (_mr *_MockContentLoaderRecorder) Load(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Load", arg0, arg1)
 }
 
 // Mock of Content interface
@@ -144,6 +146,16 @@ func (_mr *_MockContentRecorder) Head() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Head")
 }
 
+func (_m *MockContent) HtmlAttributes() Fragment {
+	ret := _m.ctrl.Call(_m, "HtmlAttributes")
+	ret0, _ := ret[0].(Fragment)
+	return ret0
+}
+
+func (_mr *_MockContentRecorder) HtmlAttributes() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "HtmlAttributes")
+}
+
 func (_m *MockContent) HttpHeader() http.Header {
 	ret := _m.ctrl.Call(_m, "HttpHeader")
 	ret0, _ := ret[0].(http.Header)
@@ -358,6 +370,18 @@ func (_mr *_MockCacheRecorder) Get(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Get", arg0)
 }
 
+func (_m *MockCache) GetStale(_param0 string) (interface{}, bool, bool) {
+	ret := _m.ctrl.Call(_m, "GetStale", _param0)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(bool)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockCacheRecorder) GetStale(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetStale", arg0)
+}
+
 func (_m *MockCache) Invalidate() {
 	_m.ctrl.Call(_m, "Invalidate")
 }
@@ -374,6 +398,42 @@ func (_mr *_MockCacheRecorder) PurgeEntries(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "PurgeEntries", arg0)
 }
 
+func (_m *MockCache) PurgeEntriesByLabel(_param0 func(string) bool) {
+	_m.ctrl.Call(_m, "PurgeEntriesByLabel", _param0)
+}
+
+func (_mr *_MockCacheRecorder) PurgeEntriesByLabel(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PurgeEntriesByLabel", arg0)
+}
+
+func (_m *MockCache) Entries() []cache.EntryInfo {
+	ret := _m.ctrl.Call(_m, "Entries")
+	ret0, _ := ret[0].([]cache.EntryInfo)
+	return ret0
+}
+
+func (_mr *_MockCacheRecorder) Entries() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Entries")
+}
+
+func (_m *MockCache) Stats() map[string]interface{} {
+	ret := _m.ctrl.Call(_m, "Stats")
+	ret0, _ := ret[0].(map[string]interface{})
+	return ret0
+}
+
+func (_mr *_MockCacheRecorder) Stats() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Stats")
+}
+
+func (_m *MockCache) PurgeEntriesByTags(_param0 []string) {
+	_m.ctrl.Call(_m, "PurgeEntriesByTags", _param0)
+}
+
+func (_mr *_MockCacheRecorder) PurgeEntriesByTags(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PurgeEntriesByTags", arg0)
+}
+
 func (_m *MockCache) Set(_param0 string, _param1 string, _param2 int, _param3 interface{}) {
 	_m.ctrl.Call(_m, "Set", _param0, _param1, _param2, _param3)
 }
@@ -381,3 +441,11 @@ func (_m *MockCache) Set(_param0 string, _param1 string, _param2 int, _param3 in
 func (_mr *_MockCacheRecorder) Set(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Set", arg0, arg1, arg2, arg3)
 }
+
+func (_m *MockCache) SetWithOptions(_param0 string, _param1 string, _param2 int, _param3 interface{}, _param4 cache.EntryOptions) {
+	_m.ctrl.Call(_m, "SetWithOptions", _param0, _param1, _param2, _param3, _param4)
+}
+
+func (_mr *_MockCacheRecorder) SetWithOptions(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetWithOptions", arg0, arg1, arg2, arg3, arg4)
+}
@@ -6,6 +6,8 @@ package composition
 //go:generate sh ../scripts/mockgen.sh
 
 import (
+	"context"
+	"github.com/tarent/lib-compose/cache"
 	"io"
 	"net/http"
 )
@@ -19,8 +21,9 @@ type Fragment interface {
 
 type ContentLoader interface {
 	// Load synchronously loads a content.
-	// The loader has to ensure to return the call withing the supplied timeout.
-	Load(fd *FetchDefinition) (content Content, err error)
+	// The loader has to ensure to return the call withing the supplied timeout
+	// and to stop loading, if the context is done.
+	Load(ctx context.Context, fd *FetchDefinition) (content Content, err error)
 }
 
 type ContentParser interface {
@@ -39,9 +42,27 @@ type FetchResultSupplier interface {
 	Empty() bool
 }
 
+// FetchResultStreamer is a FetchResultSupplier, which is able to
+// supply the results, while other fetch jobs are still in progress.
+type FetchResultStreamer interface {
+	FetchResultSupplier
+
+	// ScheduledResults returns the results of all fetch jobs added so far, without waiting for them.
+	// Only Def and Hash may be read from results of jobs, which are not done.
+	ScheduledResults() []*FetchResult
+
+	// WaitForNewResults blocks until more than known fetch jobs are done, or all jobs are done.
+	// It returns the results of the done jobs in order of completion, a copy of the
+	// meta JSON composed so far and true, if there are no more outstanding jobs.
+	WaitForNewResults(known int) (finished []*FetchResult, metaJSON map[string]interface{}, complete bool)
+}
+
 type CacheStrategy interface {
 	Hash(method string, url string, requestHeader http.Header) string
 	IsCacheable(method string, url string, statusCode int, requestHeader http.Header, responseHeader http.Header) bool
+
+	// EntryOptions returns the settings for the cache entry of a response.
+	EntryOptions(method string, url string, statusCode int, requestHeader http.Header, responseHeader http.Header) cache.EntryOptions
 }
 
 // Params is a value type for a parameter map
@@ -80,6 +101,9 @@ type Content interface {
 	// The attributes for the body element
 	BodyAttributes() Fragment
 
+	// The attributes for the html element
+	HtmlAttributes() Fragment
+
 	// Reader returns the stream with the content, of any.
 	// If Reader() == nil, no stream is available an it contains parsed data, only.
 	Reader() io.ReadCloser
@@ -102,6 +126,26 @@ type ContentMerger interface {
 	GetHtml() ([]byte, error)
 }
 
+// StreamingContentMerger is a ContentMerger, which is able to
+// write the html while further contents are still loaded.
+type StreamingContentMerger interface {
+	ContentMerger
+
+	// WriteHtml writes the html to the writer.
+	// If a body fragment is missing, awaitContent is called, which may add further contents.
+	// awaitContent returns false, if no further contents are expected.
+	WriteHtml(w io.Writer, awaitContent func() bool) error
+}
+
+// NonceContentMerger is a ContentMerger, which adds the nonce
+// of the Content-Security-Policy to the script and style elements.
+type NonceContentMerger interface {
+	ContentMerger
+
+	// SetNonce sets the nonce for the next html, the merger returns.
+	SetNonce(nonce string)
+}
+
 type ResponseProcessor interface {
 	// Process html from responsebody before composition is triggered
 	// May create a new Reader inside the ResponseBody
@@ -115,7 +159,25 @@ type ErrorHandler interface {
 
 type Cache interface {
 	Get(hash string) (cacheObject interface{}, found bool)
+
+	// GetStale returns an entry, even if it is expired.
+	// The flags tell, whether it may be served while it is reloaded, or if reloading fails.
+	GetStale(hash string) (cacheObject interface{}, staleWhileRevalidate bool, staleIfError bool)
+
 	Set(hash string, label string, memorySize int, cacheObject interface{})
+	SetWithOptions(hash string, label string, memorySize int, cacheObject interface{}, options cache.EntryOptions)
 	Invalidate()
 	PurgeEntries(keys []string)
+
+	// PurgeEntriesByTags removes all entries, which were stored with at least one of the tags.
+	PurgeEntriesByTags(tags []string)
+
+	// PurgeEntriesByLabel removes all entries, which labels are matched by the function.
+	PurgeEntriesByLabel(match func(label string) bool)
+
+	// Entries returns a description of all entries.
+	Entries() []cache.EntryInfo
+
+	// Stats returns the statistics of the cache.
+	Stats() map[string]interface{}
 }
@@ -0,0 +1,178 @@
+package composition
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContentTypeUicJson is the media type of contents, which are parsed by the JsonContentParser.
+const ContentTypeUicJson = "application/vnd.uic+json"
+
+// JsonContentParser parses a content in the json format, which is an alternative to the html vocabulary:
+//
+//  {
+//    "html_attributes": {"lang": "de"},
+//    "head": "<title>Example</title>",
+//    "body_attributes": {"class": "example"},
+//    "body": "<p>the default fragment</p>",
+//    "fragments": {"headline": "<h1>Example</h1><uic-include src=\"foo#content\"/>"},
+//    "tail": "<script src=\"example.js\"></script>",
+//    "meta": {"foo": "bar"},
+//    "fetch": [{"src": "http://example.com/foo", "name": "foo", "timeout": 42000, "required": true}],
+//    "dependencies": {"bar": {"some": "param"}}
+//  }
+//
+// All properties are optional. The fragments, the body and the tail may contain the
+// elements uic-include and uic-remove of the html vocabulary, as well as the template syntax.
+type JsonContentParser struct {
+}
+
+type jsonContent struct {
+	HtmlAttributes map[string]string `json:"html_attributes"`
+	Head           string            `json:"head"`
+	BodyAttributes map[string]string `json:"body_attributes"`
+	Body           *string           `json:"body"`
+	Fragments      map[string]string `json:"fragments"`
+	Tail           *string           `json:"tail"`
+	Meta           json.RawMessage   `json:"meta"`
+	Fetch          []jsonFetch       `json:"fetch"`
+	Dependencies   map[string]Params `json:"dependencies"`
+}
+
+type jsonFetch struct {
+	Src          string `json:"src"`
+	Name         string `json:"name"`
+	Timeout      int    `json:"timeout"` // in milliseconds
+	Required     bool   `json:"required"`
+	DiscoveredBy string `json:"discoveredby"`
+}
+
+func (parser *JsonContentParser) Parse(c *MemoryContent, in io.Reader) error {
+	jc := jsonContent{}
+	decoder := json.NewDecoder(in)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&jc); err != nil {
+		return fmt.Errorf("error while parsing json content: %v", err)
+	}
+
+	if len(jc.HtmlAttributes) > 0 {
+		c.htmlAttributes = StringFragment(joinAttrs(sortedAttrs(jc.HtmlAttributes)))
+	}
+
+	if head := strings.TrimSpace(jc.Head); head != "" {
+		c.head = StringFragment(head)
+	}
+
+	if len(jc.BodyAttributes) > 0 {
+		c.bodyAttributes = StringFragment(joinAttrs(sortedAttrs(jc.BodyAttributes)))
+	}
+
+	if jc.Body != nil {
+		if _, exist := jc.Fragments[""]; exist {
+			return fmt.Errorf("default fragment defined twice, in body and in fragments")
+		}
+		if err := parseJsonFragment(c, "", *jc.Body); err != nil {
+			return err
+		}
+	}
+
+	// the fragments are parsed in the order of their names, so that the required contents have a stable order
+	names := make([]string, 0, len(jc.Fragments))
+	for name := range jc.Fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := parseJsonFragment(c, name, jc.Fragments[name]); err != nil {
+			return err
+		}
+	}
+
+	if jc.Tail != nil {
+		f, err := parseFragment(html.NewTokenizer(strings.NewReader(*jc.Tail)), c)
+		if err != nil {
+			return err
+		}
+		c.tail = f
+	}
+
+	if len(jc.Meta) > 0 {
+		if err := json.Unmarshal(jc.Meta, &c.meta); err != nil {
+			return fmt.Errorf("error while parsing meta of json content: %v", err)
+		}
+	}
+
+	for _, jf := range jc.Fetch {
+		fd, err := jf.fetchDefinition()
+		if err != nil {
+			return err
+		}
+		c.addRequiredContent(fd)
+	}
+
+	addDependencies(c, jc.Dependencies)
+	return nil
+}
+
+func parseJsonFragment(c *MemoryContent, name string, fragment string) error {
+	f, err := parseFragment(html.NewTokenizer(strings.NewReader(fragment)), c)
+	if err != nil {
+		return err
+	}
+	c.body[name] = f
+	return nil
+}
+
+// addDependencies adds the dependencies in the order of their names.
+func addDependencies(c *MemoryContent, deps map[string]Params) {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params := deps[name]
+		if params == nil {
+			params = Params{}
+		}
+		c.addDependency(name, params)
+	}
+}
+
+func (jf jsonFetch) fetchDefinition() (*FetchDefinition, error) {
+	src := strings.TrimSpace(jf.Src)
+	if src == "" {
+		return nil, fmt.Errorf("fetch definition without src in json content")
+	}
+
+	fd := &FetchDefinition{
+		URL:      src,
+		Name:     jf.Name,
+		Timeout:  time.Millisecond * time.Duration(jf.Timeout),
+		Required: jf.Required,
+	}
+	if fd.Name == "" {
+		fd.Name = urlToName(fd.URL)
+	}
+	if jf.DiscoveredBy != "" {
+		fd.DiscoveredBy(jf.DiscoveredBy)
+	}
+	return fd, nil
+}
+
+// sortedAttrs returns the attributes ordered by their keys
+func sortedAttrs(attrMap map[string]string) []html.Attribute {
+	attrs := make([]html.Attribute, 0, len(attrMap))
+	for key, val := range attrMap {
+		attrs = append(attrs, html.Attribute{Key: key, Val: val})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	return attrs
+}
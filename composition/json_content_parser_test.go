@@ -0,0 +1,134 @@
+package composition
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jsonContentIntegrationTest = `{
+  "html_attributes": {"lang": "de", "class": "no-js"},
+  "head": "<title>Example</title>",
+  "body_attributes": {"id": "main", "class": "example"},
+  "body": "<p>the default fragment</p><uic-include src=\"foo#content\" required=\"true\"/>",
+  "fragments": {
+    "headline": "<h1>Example</h1><span uic-remove>removed</span><uic-include src=\"bar#content\" param-some=\"value\"/>"
+  },
+  "tail": "<script src=\"example.js\"></script>",
+  "meta": {"foo": "bar", "count": 2},
+  "fetch": [
+    {"src": "http://example.com/foo", "name": "foo", "timeout": 42000, "required": true},
+    {"src": "http://example.com/bar"}
+  ],
+  "dependencies": {"baz": {"other": "param"}}
+}`
+
+func Test_JsonContentParser_Parse(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	parser := &JsonContentParser{}
+	c := NewMemoryContent()
+
+	// when
+	err := parser.Parse(c, strings.NewReader(jsonContentIntegrationTest))
+
+	// then
+	a.NoError(err)
+	eqFragment(t, "<title>Example</title>", c.Head())
+	a.Equal(StringFragment(`class="no-js" lang="de"`), c.HtmlAttributes())
+	a.Equal(StringFragment(`class="example" id="main"`), c.BodyAttributes())
+	eqFragment(t, "<p>the default fragment</p>§[> foo#content]§", c.Body()[""])
+	eqFragment(t, "<h1>Example</h1>§[#> bar#content]§§[/bar#content]§", c.Body()["headline"])
+	eqFragment(t, `<script src="example.js"></script>`, c.Tail())
+	a.Equal(map[string]interface{}{"foo": "bar", "count": float64(2)}, c.Meta())
+
+	a.Equal(map[string]Params{
+		"foo": Params{},
+		"bar": Params{"some": "value"},
+		"baz": Params{"other": "param"},
+	}, c.Dependencies())
+
+	required := c.RequiredContent()
+	a.Equal(2, len(required))
+	a.Equal(&FetchDefinition{URL: "http://example.com/foo", Name: "foo", Timeout: 42 * time.Second, Required: true}, required[0])
+	a.Equal("http://example.com/bar", required[1].Name)
+	a.False(required[1].Required)
+}
+
+func Test_JsonContentParser_RequiredContentInOrderOfFragmentNames(t *testing.T) {
+	a := assert.New(t)
+
+	// given fragments with esi includes
+	json := `{"fragments": {
+		"c": "<esi:include src=\"http://example.com/c\"/>",
+		"a": "<esi:include src=\"http://example.com/a\"/>",
+		"b": "<esi:include src=\"http://example.com/b\"/>"
+	}}`
+
+	for i := 0; i < 10; i++ {
+		// when
+		c := NewMemoryContent()
+		err := (&JsonContentParser{}).Parse(c, strings.NewReader(json))
+
+		// then the includes are required in the order of the fragment names
+		a.NoError(err)
+		urls := []string{}
+		for _, fd := range c.RequiredContent() {
+			urls = append(urls, fd.URL)
+		}
+		a.Equal([]string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}, urls)
+	}
+}
+
+func Test_JsonContentParser_ParseEmpty(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	err := (&JsonContentParser{}).Parse(c, strings.NewReader(`{}`))
+
+	a.NoError(err)
+	a.Nil(c.Head())
+	a.Nil(c.Tail())
+	a.Nil(c.BodyAttributes())
+	a.Nil(c.HtmlAttributes())
+	a.Equal(0, len(c.Body()))
+	a.Equal(0, len(c.RequiredContent()))
+}
+
+func Test_JsonContentParser_Errors(t *testing.T) {
+	tests := []string{
+		`no json`,
+		`{"unknown": "property"}`,
+		`{"meta": "no object"}`,
+		`{"fetch": [{"name": "without src"}]}`,
+		`{"body": "one", "fragments": {"": "two"}}`,
+	}
+	for _, test := range tests {
+		err := (&JsonContentParser{}).Parse(NewMemoryContent(), strings.NewReader(test))
+		assert.Error(t, err, test)
+	}
+}
+
+func Test_JsonContentParser_ChosenByContentType(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypeUicJson+"; charset=utf-8")
+		w.Write([]byte(`{"body": "<p>Hello</p>"}`))
+	}))
+	defer server.Close()
+
+	// when
+	c, err := NewHttpContentLoader().Load(context.Background(), NewFetchDefinition(server.URL))
+
+	// then
+	a.NoError(err)
+	a.Nil(c.Reader())
+	eqFragment(t, "<p>Hello</p>", c.Body()[""])
+}
@@ -3,17 +3,20 @@ package composition
 import (
 	"io"
 	"net/http"
+	"sort"
 )
 
 type MemoryContent struct {
 	name            string
 	requiredContent map[string]*FetchDefinition // key ist the url
 	dependencies    map[string]Params
+	order           []contentRef // the required content and the dependencies in document order
 	meta            map[string]interface{}
 	head            Fragment
 	body            map[string]Fragment
 	tail            Fragment
 	bodyAttributes  Fragment
+	htmlAttributes  Fragment
 	reader          io.ReadCloser
 	httpHeader      http.Header
 	httpStatusCode  int
@@ -49,14 +52,83 @@ func (c *MemoryContent) Name() string {
 	return c.name
 }
 
+// contentRef references a required content or a dependency of a content.
+type contentRef struct {
+	fd     *FetchDefinition // the required content
+	name   string           // or the name of the dependency
+	params Params
+}
+
+// RequiredContent returns the required content in the order, it was added.
 func (c *MemoryContent) RequiredContent() []*FetchDefinition {
 	deps := make([]*FetchDefinition, 0, len(c.requiredContent))
-	for _, dep := range c.requiredContent {
-		deps = append(deps, dep)
+	for _, ref := range c.orderedDependencies() {
+		if ref.fd != nil {
+			deps = append(deps, ref.fd)
+		}
 	}
 	return deps
 }
 
+// addRequiredContent adds a fetch definition for required content,
+// replacing a previous one with the same url.
+func (c *MemoryContent) addRequiredContent(fd *FetchDefinition) {
+	if _, exist := c.requiredContent[fd.URL]; !exist {
+		c.order = append(c.order, contentRef{fd: fd})
+	}
+	c.requiredContent[fd.URL] = fd
+}
+
+// addDependency adds a dependency, replacing the params of a previous one with the same name.
+func (c *MemoryContent) addDependency(name string, params Params) {
+	if _, exist := c.dependencies[name]; !exist {
+		c.order = append(c.order, contentRef{name: name})
+	}
+	c.dependencies[name] = params
+}
+
+// orderedDependencies returns the required content and the dependencies in the order, they were added.
+// Entries without order information are appended, the required content ordered by url and the dependencies by name.
+func (c *MemoryContent) orderedDependencies() []contentRef {
+	refs := make([]contentRef, 0, len(c.requiredContent)+len(c.dependencies))
+	addedURLs := make(map[string]bool, len(c.requiredContent))
+	addedNames := make(map[string]bool, len(c.dependencies))
+	for _, ref := range c.order {
+		if ref.fd != nil {
+			if fd, exist := c.requiredContent[ref.fd.URL]; exist && !addedURLs[ref.fd.URL] {
+				refs = append(refs, contentRef{fd: fd})
+				addedURLs[ref.fd.URL] = true
+			}
+		} else if params, exist := c.dependencies[ref.name]; exist && !addedNames[ref.name] {
+			refs = append(refs, contentRef{name: ref.name, params: params})
+			addedNames[ref.name] = true
+		}
+	}
+
+	urls := make([]string, 0)
+	for url := range c.requiredContent {
+		if !addedURLs[url] {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		refs = append(refs, contentRef{fd: c.requiredContent[url]})
+	}
+
+	names := make([]string, 0)
+	for name := range c.dependencies {
+		if !addedNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		refs = append(refs, contentRef{name: name, params: c.dependencies[name]})
+	}
+	return refs
+}
+
 func (c *MemoryContent) Dependencies() map[string]Params {
 	return c.dependencies
 }
@@ -81,6 +153,10 @@ func (c *MemoryContent) BodyAttributes() Fragment {
 	return c.bodyAttributes
 }
 
+func (c *MemoryContent) HtmlAttributes() Fragment {
+	return c.htmlAttributes
+}
+
 func (c *MemoryContent) Reader() io.ReadCloser {
 	return c.reader
 }
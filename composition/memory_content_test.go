@@ -22,3 +22,20 @@ func Test_MemoryContent_MemorySize(t *testing.T) {
 
 	a.Equal(80, m.MemorySize())
 }
+
+func Test_MemoryContent_RequiredContentOrder(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewMemoryContent()
+	m.addRequiredContent(NewFetchDefinition("/c"))
+	m.addRequiredContent(NewFetchDefinition("/a"))
+	m.addRequiredContent(NewFetchDefinition("/b"))
+	m.addRequiredContent(NewFetchDefinition("/a").WithName("replaced"))
+
+	deps := m.RequiredContent()
+	a.Equal(3, len(deps))
+	a.Equal("/c", deps[0].URL)
+	a.Equal("/a", deps[1].URL)
+	a.Equal("replaced", deps[1].Name)
+	a.Equal("/b", deps[2].URL)
+}
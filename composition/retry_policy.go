@@ -0,0 +1,111 @@
+package composition
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy defines, how often and when a failed fetch is repeated.
+// The attempts are only repeated for idempotent methods without a request body,
+// and only as long as the Timeout of the FetchDefinition is not exceeded.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of requests, including the first one.
+	MaxAttempts int
+
+	// Backoff is the waiting time before the first retry, which is doubled for every further retry.
+	Backoff time.Duration
+
+	// MaxBackoff limits the waiting time between two attempts.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of the waiting time, by which it is randomly reduced, between 0 and 1.
+	Jitter float64
+
+	// RetryableStatusCodes are the response status codes, which are retried.
+	RetryableStatusCodes []int
+
+	// RetryableError decides, which errors are retried.
+	// If nil, IsRetryableNetworkError is used.
+	RetryableError func(err error) bool
+}
+
+// DefaultRetryPolicy retries the fetch two times on network errors and on unavailable backends.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:          3,
+	Backoff:              50 * time.Millisecond,
+	MaxBackoff:           time.Second,
+	Jitter:               0.5,
+	RetryableStatusCodes: []int{502, 503, 504},
+}
+
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// IsRetryableNetworkError returns true for network errors, like connection resets, refused connections
+// and timeouts, but not for canceled requests or stopped redirects.
+func IsRetryableNetworkError(err error) bool {
+	if urlError, ok := err.(*url.Error); ok {
+		err = urlError.Err
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
+// appliesTo returns true, if the fetch may be repeated.
+func (p *RetryPolicy) appliesTo(fd *FetchDefinition) bool {
+	return p.MaxAttempts > 1 && idempotentMethods[fd.Method] && fd.Body == nil
+}
+
+// isRetryable returns true, if the result of an attempt should be retried.
+func (p *RetryPolicy) isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if urlError, ok := err.(*url.Error); ok && urlError.Err == redirectAttemptedError {
+			return false
+		}
+		if _, isOpen := err.(*CircuitOpenError); isOpen {
+			return false
+		}
+		if p.RetryableError != nil {
+			return p.RetryableError(err)
+		}
+		return IsRetryableNetworkError(err)
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the waiting time after the attempt with the number, starting at 1.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+	}
+	return backoff
+}
@@ -0,0 +1,177 @@
+package composition
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	a := assert.New(t)
+
+	policy := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	a.Equal(10*time.Millisecond, policy.backoff(1))
+	a.Equal(20*time.Millisecond, policy.backoff(2))
+	a.Equal(40*time.Millisecond, policy.backoff(3))
+	a.Equal(50*time.Millisecond, policy.backoff(4))
+	a.Equal(50*time.Millisecond, policy.backoff(100))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(2)
+		a.True(backoff >= 10*time.Millisecond && backoff <= 20*time.Millisecond, "backoff %v", backoff)
+	}
+}
+
+func Test_RetryPolicy_AppliesOnlyToIdempotentRequests(t *testing.T) {
+	a := assert.New(t)
+
+	policy := &DefaultRetryPolicy
+	fd := NewFetchDefinition("http://example.de")
+	a.True(policy.appliesTo(fd))
+
+	fd.Method = "POST"
+	a.False(policy.appliesTo(fd))
+
+	fd.Method = "PUT"
+	fd.Body = strings.NewReader("some body")
+	a.False(policy.appliesTo(fd))
+
+	a.False((&RetryPolicy{MaxAttempts: 1}).appliesTo(NewFetchDefinition("http://example.de")))
+}
+
+func Test_RetryPolicy_IsRetryable(t *testing.T) {
+	a := assert.New(t)
+
+	policy := &DefaultRetryPolicy
+	a.True(policy.isRetryable(&http.Response{StatusCode: 503}, nil))
+	a.False(policy.isRetryable(&http.Response{StatusCode: 500}, nil))
+	a.False(policy.isRetryable(&http.Response{StatusCode: 200}, nil))
+	a.False(policy.isRetryable(nil, &CircuitOpenError{Backend: "example.de"}))
+	a.False(policy.isRetryable(nil, errors.New("some error")))
+
+	custom := &RetryPolicy{RetryableError: func(err error) bool { return true }}
+	a.True(custom.isRetryable(nil, errors.New("some error")))
+}
+
+func Test_HttpContentLoader_RetriesUnavailableBackend(t *testing.T) {
+	a := assert.New(t)
+
+	// given a server, which is unavailable for the first two requests
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "Service Unavailable", 503)
+			return
+		}
+		w.Write([]byte("the body"))
+	}))
+	defer server.Close()
+
+	// when the content is loaded with a retry policy
+	fd := NewFetchDefinition(server.URL).WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableStatusCodes: []int{503}})
+	c, err := NewHttpContentLoader().Load(context.Background(), fd)
+
+	// then the third attempt is returned
+	a.NoError(err)
+	a.Equal(200, c.HttpStatusCode())
+	a.Equal(int32(3), atomic.LoadInt32(&requests))
+}
+
+func Test_HttpContentLoader_RetriesConnectionErrors(t *testing.T) {
+	a := assert.New(t)
+
+	// given a server, which closes the first connection without response
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("the body"))
+	}))
+	defer server.Close()
+
+	// when the content is loaded with a retry policy
+	fd := NewFetchDefinition(server.URL).WithRetryPolicy(&RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	c, err := NewHttpContentLoader().Load(context.Background(), fd)
+
+	// then the second attempt is returned
+	a.NoError(err)
+	a.Equal(200, c.HttpStatusCode())
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+}
+
+func Test_HttpContentLoader_RetriesWithinTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	// given a server, which is always unavailable
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "Service Unavailable", 503)
+	}))
+	defer server.Close()
+
+	// when the content is loaded with a backoff longer than the timeout
+	fd := NewFetchDefinition(server.URL).WithRetryPolicy(&RetryPolicy{MaxAttempts: 5, Backoff: time.Second, RetryableStatusCodes: []int{503}})
+	fd.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	c, err := NewHttpContentLoader().Load(context.Background(), fd)
+
+	// then the the result of the first attempt is returned
+	a.Error(err)
+	a.Equal(503, c.HttpStatusCode())
+	a.Equal(int32(1), atomic.LoadInt32(&requests))
+	a.True(time.Since(start) < time.Second)
+}
+
+func Test_HttpContentLoader_AllAttemptsWithinTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	// given a slow server, which is always unavailable
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(30 * time.Millisecond)
+		http.Error(w, "Service Unavailable", 503)
+	}))
+	defer server.Close()
+
+	// when the content is loaded with more attempts, than fit into the timeout
+	fd := NewFetchDefinition(server.URL).WithRetryPolicy(&RetryPolicy{MaxAttempts: 20, Backoff: 10 * time.Millisecond, RetryableStatusCodes: []int{503}})
+	fd.Timeout = 150 * time.Millisecond
+	start := time.Now()
+	_, err := NewHttpContentLoader().Load(context.Background(), fd)
+
+	// then the retries stop, when the timeout is reached
+	a.Error(err)
+	a.True(atomic.LoadInt32(&requests) > 1)
+	a.True(atomic.LoadInt32(&requests) < 20)
+	a.True(time.Since(start) < 300*time.Millisecond)
+}
+
+func Test_HttpContentLoader_NoRetryForPost(t *testing.T) {
+	a := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "Service Unavailable", 503)
+	}))
+	defer server.Close()
+
+	fd := NewFetchDefinition(server.URL).WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableStatusCodes: []int{503}})
+	fd.Method = "POST"
+	_, err := NewHttpContentLoader().Load(context.Background(), fd)
+
+	a.Error(err)
+	a.Equal(int32(1), atomic.LoadInt32(&requests))
+}
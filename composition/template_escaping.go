@@ -0,0 +1,442 @@
+package composition
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// the states of the htmlContext
+const (
+	contextText = iota
+	contextTagOpen
+	contextEndTagOpen
+	contextTagName
+	contextTag
+	contextAttrName
+	contextAfterAttrName
+	contextBeforeAttrValue
+	contextAttrValue
+	contextMarkup
+	contextComment
+	contextBogusComment
+	contextRawText
+)
+
+// the states of javascript within script elements and event handler attributes
+const (
+	jsCode = iota
+	jsSingleQuote
+	jsDoubleQuote
+	jsTemplateLiteral
+	jsLineComment
+	jsBlockComment
+)
+
+// urlAttributes contain urls, so that the scheme of values has to be checked
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"formaction": true,
+	"href":       true,
+	"poster":     true,
+	"src":        true,
+}
+
+// safeURLSchemes may be written into url attributes, urls without scheme are always allowed
+var safeURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+}
+
+// invalidURL replaces urls with unsafe schemes, e.g. javascript:
+const invalidURL = "about:invalid"
+
+// htmlContext tracks the position within the html, which is written by a template,
+// so that the values of placeholders can be escaped for their context.
+// Within script elements and event handler attributes, also the string literals
+// and comments of the javascript are tracked. Character references within
+// event handler attributes are not decoded.
+type htmlContext struct {
+	state     int
+	tagName   string
+	endTag    bool
+	attrName  string
+	quote     byte // the quote of the attribute value, or 0 if unquoted
+	valueLen  int  // the number of bytes written to the attribute value
+	rawTextOf string
+	matched   int // the number of bytes matched of a comment delimiter or the end tag of the raw text
+
+	js       int
+	jsEscape bool // the last byte within a string literal was a backslash
+	jsSlash  bool // the last byte of the code was a slash
+	jsStar   bool // the last byte within a block comment was a star
+}
+
+// htmlContextWriter updates the context by all html written through it.
+// Fragments, which are executed nested in each other with the same writer, share the context and the loop limit.
+type htmlContextWriter struct {
+	w         io.Writer
+	execution *templateExecution
+}
+
+func newHtmlContextWriter(w io.Writer) *htmlContextWriter {
+	return &htmlContextWriter{w: w, execution: &templateExecution{context: &htmlContext{}}}
+}
+
+func (cw *htmlContextWriter) Write(p []byte) (int, error) {
+	cw.execution.context.scan(string(p))
+	return cw.w.Write(p)
+}
+
+// scan updates the context by the html text.
+// The text may be split at any position, so that the state is kept between the calls.
+func (c *htmlContext) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		c.next(text[i])
+	}
+}
+
+// next updates the context by the next byte of the html.
+func (c *htmlContext) next(b byte) {
+	switch c.state {
+	case contextText:
+		if b == '<' {
+			c.state = contextTagOpen
+		}
+
+	case contextTagOpen:
+		switch {
+		case isLetter(b):
+			c.state, c.tagName, c.endTag = contextTagName, string(lower(b)), false
+		case b == '/':
+			c.state = contextEndTagOpen
+		case b == '!':
+			c.state, c.matched = contextMarkup, 0
+		case b != '<':
+			c.state = contextText
+		}
+
+	case contextEndTagOpen:
+		switch {
+		case isLetter(b):
+			c.state, c.tagName, c.endTag = contextTagName, string(lower(b)), true
+		case b == '>':
+			c.state = contextText
+		default:
+			c.state = contextBogusComment
+		}
+
+	case contextTagName:
+		switch {
+		case b == '>':
+			c.closeTag()
+		case isSpace(b) || b == '/':
+			c.state = contextTag
+		default:
+			c.tagName += string(lower(b))
+		}
+
+	case contextTag:
+		switch {
+		case b == '>':
+			c.closeTag()
+		case isSpace(b) || b == '/':
+		default:
+			c.state, c.attrName = contextAttrName, string(lower(b))
+		}
+
+	case contextAttrName:
+		switch {
+		case b == '>':
+			c.closeTag()
+		case b == '=':
+			c.state = contextBeforeAttrValue
+			c.resetJS()
+		case isSpace(b):
+			c.state = contextAfterAttrName
+		case b == '/':
+			c.state = contextTag
+		default:
+			c.attrName += string(lower(b))
+		}
+
+	case contextAfterAttrName:
+		switch {
+		case b == '>':
+			c.closeTag()
+		case b == '=':
+			c.state = contextBeforeAttrValue
+			c.resetJS()
+		case isSpace(b):
+		default:
+			c.state, c.attrName = contextAttrName, string(lower(b))
+		}
+
+	case contextBeforeAttrValue:
+		switch {
+		case b == '>':
+			c.closeTag()
+		case b == '"' || b == '\'':
+			c.state, c.quote, c.valueLen = contextAttrValue, b, 0
+			c.resetJS()
+		case isSpace(b):
+		default:
+			c.state, c.quote, c.valueLen = contextAttrValue, 0, 1
+			c.resetJS()
+			c.scanAttrValue(b)
+		}
+
+	case contextAttrValue:
+		switch {
+		case c.quote != 0 && b == c.quote:
+			c.state = contextTag
+		case c.quote == 0 && isSpace(b):
+			c.state = contextTag
+		case c.quote == 0 && b == '>':
+			c.closeTag()
+		default:
+			c.valueLen++
+			c.scanAttrValue(b)
+		}
+
+	case contextMarkup:
+		switch {
+		case b == '-' && c.matched == 1:
+			c.state, c.matched = contextComment, 0
+		case b == '-':
+			c.matched++
+		case b == '>':
+			c.state = contextText
+		default:
+			c.state = contextBogusComment
+		}
+
+	case contextComment:
+		switch {
+		case b == '-':
+			c.matched++
+		case b == '>' && c.matched >= 2:
+			c.state = contextText
+		default:
+			c.matched = 0
+		}
+
+	case contextBogusComment:
+		if b == '>' {
+			c.state = contextText
+		}
+
+	case contextRawText:
+		if c.rawTextOf == "script" {
+			c.scanJS(b)
+		}
+		end := "</" + c.rawTextOf
+		switch {
+		case lower(b) == end[c.matched]:
+			c.matched++
+			if c.matched == len(end) {
+				c.state, c.tagName, c.endTag, c.matched = contextTagName, c.rawTextOf, true, 0
+			}
+		case b == '<':
+			c.matched = 1
+		default:
+			c.matched = 0
+		}
+	}
+}
+
+func (c *htmlContext) closeTag() {
+	switch {
+	case c.endTag && c.rawTextOf != "" && c.tagName != c.rawTextOf:
+		// no end tag of the raw text element, e.g. </scripts>
+		c.state = contextRawText
+	case !c.endTag && (c.tagName == "script" || c.tagName == "style"):
+		c.state, c.rawTextOf, c.matched = contextRawText, c.tagName, 0
+		c.resetJS()
+	default:
+		c.state, c.rawTextOf = contextText, ""
+	}
+}
+
+// isEventHandler checks, if the current attribute contains javascript
+func (c *htmlContext) isEventHandler() bool {
+	return strings.HasPrefix(c.attrName, "on")
+}
+
+func (c *htmlContext) scanAttrValue(b byte) {
+	if c.isEventHandler() {
+		c.scanJS(b)
+	}
+}
+
+func (c *htmlContext) resetJS() {
+	c.js, c.jsEscape, c.jsSlash, c.jsStar = jsCode, false, false, false
+}
+
+// scanJS updates the javascript state by the next byte.
+// Regular expression literals are not recognized.
+func (c *htmlContext) scanJS(b byte) {
+	switch c.js {
+	case jsCode:
+		slash := c.jsSlash
+		c.jsSlash = false
+		switch {
+		case b == '\'':
+			c.js = jsSingleQuote
+		case b == '"':
+			c.js = jsDoubleQuote
+		case b == '`':
+			c.js = jsTemplateLiteral
+		case b == '/' && slash:
+			c.js = jsLineComment
+		case b == '/':
+			c.jsSlash = true
+		case b == '*' && slash:
+			c.js, c.jsStar = jsBlockComment, false
+		}
+
+	case jsSingleQuote, jsDoubleQuote, jsTemplateLiteral:
+		switch {
+		case c.jsEscape:
+			c.jsEscape = false
+		case b == '\\':
+			c.jsEscape = true
+		case (b == '\'' && c.js == jsSingleQuote) || (b == '"' && c.js == jsDoubleQuote) || (b == '`' && c.js == jsTemplateLiteral):
+			c.js = jsCode
+		case b == '\n' && c.js != jsTemplateLiteral:
+			c.js = jsCode
+		}
+
+	case jsLineComment:
+		if b == '\n' || b == '\r' {
+			c.js = jsCode
+		}
+
+	case jsBlockComment:
+		if b == '/' && c.jsStar {
+			c.js = jsCode
+		}
+		c.jsStar = b == '*'
+	}
+}
+
+// escape returns the value escaped for the current context.
+// The context is updated by the htmlContextWriter, when the value is written.
+func (c *htmlContext) escape(value interface{}) string {
+	if safe, isSafe := value.(SafeString); isSafe {
+		return string(safe)
+	}
+
+	s := valueToString(value)
+	_, isJson := value.(jsonString)
+	switch c.state {
+	case contextRawText:
+		if c.rawTextOf == "style" {
+			return escapeCSS(s)
+		}
+		return c.escapeJSValue(s, isJson)
+
+	case contextBeforeAttrValue, contextAttrValue:
+		beforeValue := c.state == contextBeforeAttrValue
+		switch {
+		case (beforeValue || c.valueLen == 0) && urlAttributes[c.attrName] && !isSafeURL(s):
+			s = invalidURL
+		case c.isEventHandler():
+			s = c.escapeJSValue(s, isJson)
+		case c.attrName == "style":
+			s = escapeCSS(s)
+		}
+		if beforeValue || c.quote == 0 {
+			return escapeWithinTag(s)
+		}
+		return html.EscapeString(s)
+
+	case contextTagOpen, contextEndTagOpen, contextTagName, contextTag, contextAttrName, contextAfterAttrName:
+		return escapeWithinTag(s)
+	}
+	return html.EscapeString(s)
+}
+
+// escapeJSValue escapes the value for javascript. Within string literals and comments, the characters are escaped.
+// Within the code, the value is written as string literal, so that it can not inject code, or as it is, if it is json.
+func (c *htmlContext) escapeJSValue(s string, isJson bool) string {
+	if c.js != jsCode {
+		return escapeJS(s)
+	}
+	if isJson {
+		return s
+	}
+	return `"` + escapeJS(s) + `"`
+}
+
+// escapeWithinTag escapes also the characters, which separate attributes
+func escapeWithinTag(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer("=", "&#61;", "/", "&#47;", " ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;", "`", "&#96;").Replace(s)
+}
+
+// escapeJS escapes all characters, but letters, digits and spaces as unicode escape sequences,
+// so that the value is safe within string literals and can not be executed outside of them.
+func escapeJS(s string) string {
+	buff := strings.Builder{}
+	for _, r := range s {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ') {
+			buff.WriteRune(r)
+		} else if r > 0xffff {
+			r1, r2 := utf16Surrogates(r)
+			fmt.Fprintf(&buff, `\u%04x\u%04x`, r1, r2)
+		} else {
+			fmt.Fprintf(&buff, `\u%04x`, r)
+		}
+	}
+	return buff.String()
+}
+
+// escapeCSS escapes all characters, but letters, digits and spaces as css escape sequences
+func escapeCSS(s string) string {
+	buff := strings.Builder{}
+	for _, r := range s {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ') {
+			buff.WriteRune(r)
+		} else {
+			fmt.Fprintf(&buff, `\%06x`, r)
+		}
+	}
+	return buff.String()
+}
+
+func utf16Surrogates(r rune) (rune, rune) {
+	r -= 0x10000
+	return 0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff
+}
+
+// isSafeURL checks, that the url is relative or has a safe scheme
+func isSafeURL(s string) bool {
+	s = strings.TrimSpace(s)
+	colon := strings.IndexByte(s, ':')
+	if colon == -1 || strings.ContainsAny(s[:colon], "/?#") {
+		return true
+	}
+	return safeURLSchemes[strings.ToLower(s[:colon])]
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
@@ -0,0 +1,145 @@
+package composition
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func Test_TemplateEscaping_Contexts(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"xss":     `<script>alert("x")</script>`,
+		"quote":   `" onmouseover="alert(1)`,
+		"attrs":   `onclick=alert(1)`,
+		"js":      `javascript:alert(1)`,
+		"url":     "https://example.com/?a=b&c=d",
+		"path":    "/some/path:with/colon",
+		"jsValue": `"; alert(1); "`,
+		"css":     `red;} body{display:none`,
+		"comment": `--><script>`,
+		"request": map[string]interface{}{"params": url.Values{"q": {"<b>"}}},
+		"list":    []interface{}{"<b>"},
+		"handler": `');alert(1);//`,
+		"ident":   "alert",
+		"obj":     map[string]interface{}{"a": "'"},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`<p>§[xss]§</p>`, `<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>`},
+		{`<p>§[request.params.q]§</p>`, `<p>&lt;b&gt;</p>`},
+		{`<input value="§[quote]§">`, `<input value="&#34; onmouseover=&#34;alert(1)">`},
+		{`<input value='§[quote]§'>`, `<input value='&#34; onmouseover=&#34;alert(1)'>`},
+		{`<input value=§[attrs]§>`, `<input value=onclick&#61;alert(1)>`},
+		{`<div §[attrs]§>`, `<div onclick&#61;alert(1)>`},
+		{`<a href="§[js]§">`, `<a href="about:invalid">`},
+		{`<a HREF='§[js]§'>`, `<a HREF='about:invalid'>`},
+		{`<a href=§[js]§>`, `<a href=about:invalid>`},
+		{`<a href="§[url]§">`, `<a href="https://example.com/?a=b&amp;c=d">`},
+		{`<a href="§[path]§">`, `<a href="/some/path:with/colon">`},
+		{`<a href="/search?q=§[js]§">`, `<a href="/search?q=javascript:alert(1)">`},
+		{`<img src="§[js | raw]§">`, `<img src="javascript:alert(1)">`},
+		{`<script>var x = "§[jsValue]§";</script>`, `<script>var x = "\u0022\u003b alert\u00281\u0029\u003b \u0022";</script>`},
+		{`<script>var x = "§[xss]§";</script><p>§[xss]§</p>`,
+			`<script>var x = "\u003cscript\u003ealert\u0028\u0022x\u0022\u0029\u003c\u002fscript\u003e";</script>` +
+				`<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>`},
+		{`<script>var a = §[ident]§;</script>`, `<script>var a = "alert";</script>`},
+		{`<script>var a = §[obj | json]§, b = '§[obj | json]§';</script>`,
+			`<script>var a = {"a":"'"}, b = '\u007b\u0022a\u0022\u003a\u0022\u0027\u0022\u007d';</script>`},
+		{"<script>// it's a comment\nvar a = §[ident]§; /* don't */ var b = `§[ident]§`;</script>",
+			"<script>// it's a comment\nvar a = \"alert\"; /* don't */ var b = `alert`;</script>"},
+		{`<script>var a = "<\/scripts>", b = '\'§[ident]§';</script><p>§[ident]§</p>`, `<script>var a = "<\/scripts>", b = '\'alert';</script><p>alert</p>`},
+		{`<button onclick="track('§[handler]§')">`, `<button onclick="track('\u0027\u0029\u003balert\u00281\u0029\u003b\u002f\u002f')">`},
+		{`<button ONCLICK='track(§[ident]§)'>`, `<button ONCLICK='track(&#34;alert&#34;)'>`},
+		{`<button onclick=§[ident]§()>`, `<button onclick=&#34;alert&#34;()>`},
+		{`<p style="color: §[css]§">`, `<p style="color: red\00003b\00007d body\00007bdisplay\00003anone">`},
+		{`<style>p { color: §[css]§ }</style>`, `<style>p { color: red\00003b\00007d body\00007bdisplay\00003anone }</style>`},
+		{`<!-- §[comment]§ -->`, `<!-- --&gt;&lt;script&gt; -->`},
+		{`<p>§[xss | raw]§</p>`, `<p><script>alert("x")</script></p>`},
+		{`<p title="a §[?xss]§§[quote]§§[/?]§">`, `<p title="a &#34; onmouseover=&#34;alert(1)">`},
+		{`<ul>§[*list]§<li class="§[item]§">§[item]§</li>§[/*]§</ul>`, `<ul><li class="&lt;b&gt;">&lt;b&gt;</li></ul>`},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil)
+		a.NoError(err, test.template)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_TemplateEscaping_ContextOfNestedFragments(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	cm := NewContentMerge(map[string]interface{}{"ident": "alert"})
+	cm.AddContent(&MemoryContent{
+		name: LayoutFragmentName,
+		body: map[string]Fragment{"": StringFragment(`§[> script]§ §[ident]§;</script><p>§[#> title]§§[/title]§§[ident]§">§[ident]§</p>`)},
+	}, 0)
+	cm.AddContent(&MemoryContent{
+		name: "fragment",
+		body: map[string]Fragment{
+			"script": StringFragment(`<script>var a =`),
+			"title":  StringFragment(`<a onclick='f(`),
+		},
+	}, 0)
+
+	// when
+	html, err := cm.GetHtml()
+
+	// then
+	a.NoError(err)
+	a.Contains(string(html), `<script>var a = "alert";</script><p><a onclick='f(&#34;alert&#34;">alert</p>`)
+}
+
+func Test_TemplateEscaping_ScanInChunks(t *testing.T) {
+	a := assert.New(t)
+
+	texts := []string{
+		`<p title="a`,
+		`<!-- <script> --><a href=x onclick='f("`,
+		`<!DOCTYPE html><script>var a = "</scripts>" // '`,
+		`<style>p { color: red }</style><input value='`,
+		`<script>/* '</script> <p `,
+	}
+
+	for _, text := range texts {
+		// given
+		whole, chunked := &htmlContext{}, &htmlContext{}
+
+		// when
+		whole.scan(text)
+		for i := range text {
+			chunked.scan(text[i : i+1])
+		}
+
+		// then
+		a.Equal(whole, chunked, text)
+		a.NotEqual(contextText, whole.state, text)
+	}
+}
+
+func Test_TemplateEscaping_NoEscapingOfUrls(t *testing.T) {
+	a := assert.New(t)
+
+	result, err := expandTemplateVars("http://example.com/§[path]§?q=§[q | urlquery]§", map[string]interface{}{"path": "a&b", "q": "c d"})
+	a.NoError(err)
+	a.Equal("http://example.com/a&b?q=c+d", result)
+}
+
+func Test_TemplateEscaping_IsSafeURL(t *testing.T) {
+	a := assert.New(t)
+
+	for _, safe := range []string{"", "/path", "relative/path", "?q=a:b", "#anchor", "http://a", "HTTPS://a", "mailto:a@b", "tel:123", "//example.com/a:b"} {
+		a.True(isSafeURL(safe), safe)
+	}
+	for _, unsafe := range []string{"javascript:alert(1)", " JavaScript:alert(1)", "data:text/html,x", "vbscript:x"} {
+		a.False(isSafeURL(unsafe), unsafe)
+	}
+}
@@ -0,0 +1,218 @@
+package composition
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateFilter transforms the value of a placeholder, e.g. §[ name | upper ]§.
+// The args are the literals after the name of the filter, e.g. 20 in §[ text | truncate 20 ]§.
+// The value is nil, if the variable does not exist.
+type TemplateFilter func(value interface{}, args []string) (interface{}, error)
+
+// SafeString is written into the output without escaping.
+// Filters should only return it for trusted content.
+type SafeString string
+
+// jsonString is a json encoded value, which is a valid literal within scripts
+type jsonString string
+
+var templateFilters = struct {
+	filters map[string]TemplateFilter
+	mutex   sync.RWMutex
+}{
+	filters: map[string]TemplateFilter{
+		"raw":      rawFilter,
+		"json":     jsonFilter,
+		"urlquery": urlqueryFilter,
+		"default":  defaultFilter,
+		"upper":    upperFilter,
+		"lower":    lowerFilter,
+		"truncate": truncateFilter,
+		"date":     dateFilter,
+	},
+}
+
+// RegisterTemplateFilter registers a filter for the use in placeholders.
+// A filter with the same name is replaced, so also the built in filters can be replaced.
+func RegisterTemplateFilter(name string, filter TemplateFilter) {
+	templateFilters.mutex.Lock()
+	defer templateFilters.mutex.Unlock()
+	templateFilters.filters[name] = filter
+}
+
+func getTemplateFilter(name string) (TemplateFilter, bool) {
+	templateFilters.mutex.RLock()
+	defer templateFilters.mutex.RUnlock()
+	filter, exist := templateFilters.filters[name]
+	return filter, exist
+}
+
+// applyFilters applies the filter pipeline, e.g. ["default 'n/a'", "upper"] to the value.
+func applyFilters(value interface{}, pipeline []string) (interface{}, error) {
+	for _, filterDefinition := range pipeline {
+		parts := splitOutsideQuotes(filterDefinition, ' ')
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("empty filter in placeholder")
+		}
+		filter, exist := getTemplateFilter(parts[0])
+		if !exist {
+			return nil, fmt.Errorf("unknown template filter %q", parts[0])
+		}
+		args := make([]string, 0, len(parts)-1)
+		for _, arg := range parts[1:] {
+			args = append(args, unquote(arg))
+		}
+		var err error
+		if value, err = filter(value, args); err != nil {
+			return nil, fmt.Errorf("error in template filter %q: %v", parts[0], err)
+		}
+	}
+	return value, nil
+}
+
+// splitOutsideQuotes splits s at the separator, if it is not within single or double quotes.
+// Empty parts are dropped, if the separator is a space.
+func splitOutsideQuotes(s string, separator rune) []string {
+	parts := make([]string, 0, 2)
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == separator:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + utf8.RuneLen(c)
+		}
+	}
+	parts = append(parts, strings.TrimSpace(s[start:]))
+
+	if separator != ' ' {
+		return parts
+	}
+	nonEmpty := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return nonEmpty
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func valueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case SafeString:
+		return string(v)
+	case jsonString:
+		return string(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func rawFilter(value interface{}, args []string) (interface{}, error) {
+	return SafeString(valueToString(value)), nil
+}
+
+func jsonFilter(value interface{}, args []string) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return jsonString(b), nil
+}
+
+func urlqueryFilter(value interface{}, args []string) (interface{}, error) {
+	return url.QueryEscape(valueToString(value)), nil
+}
+
+func defaultFilter(value interface{}, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the default value as argument")
+	}
+	if value == nil || valueToString(value) == "" {
+		return args[0], nil
+	}
+	return value, nil
+}
+
+func upperFilter(value interface{}, args []string) (interface{}, error) {
+	return strings.ToUpper(valueToString(value)), nil
+}
+
+func lowerFilter(value interface{}, args []string) (interface{}, error) {
+	return strings.ToLower(valueToString(value)), nil
+}
+
+// truncateFilter shortens a text to the maximum number of characters from the first argument,
+// and appends the optional second argument, e.g. "...", if the text was shortened.
+func truncateFilter(value interface{}, args []string) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("expected the length and an optional suffix as arguments")
+	}
+	length, err := strconv.Atoi(args[0])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid length %q", args[0])
+	}
+
+	s := valueToString(value)
+	if utf8.RuneCountInString(s) <= length {
+		return s, nil
+	}
+	runes := []rune(s)
+	if len(args) == 2 {
+		return string(runes[:length]) + args[1], nil
+	}
+	return string(runes[:length]), nil
+}
+
+// dateFilter formats a date with the go layout from the argument, e.g. "02.01.2006".
+// The value may be a time.Time, a string in RFC 3339 format or the unix time in seconds.
+func dateFilter(value interface{}, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the layout as argument")
+	}
+
+	var t time.Time
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		t = v
+	case float64:
+		t = time.Unix(int64(v), 0).UTC()
+	case int:
+		t = time.Unix(int64(v), 0).UTC()
+	case int64:
+		t = time.Unix(v, 0).UTC()
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("no date %v", value)
+	}
+	return t.Format(args[0]), nil
+}
@@ -0,0 +1,111 @@
+package composition
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TemplateFilters(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"request": map[string]interface{}{
+			"params": map[string]interface{}{"q": "a b&c"},
+		},
+		"name":      "Lib Compose",
+		"empty":     "",
+		"text":      "Hällo World",
+		"published": "2017-03-14T15:09:26Z",
+		"timestamp": float64(1489504166),
+		"tags":      []interface{}{"a", "b"},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`§[ request.params.q | urlquery ]§`, "a+b%26c"},
+		{`§[ name | upper ]§`, "LIB COMPOSE"},
+		{`§[ name|lower ]§`, "lib compose"},
+		{`§[ not_existent | default "n/a" ]§`, "n/a"},
+		{`§[ empty | default 'n/a' ]§`, "n/a"},
+		{`§[ name | default "n/a" ]§`, "Lib Compose"},
+		{`§[ not_existent | default "a | b" | upper ]§`, "A | B"},
+		{`§[ text | truncate 5 ]§`, "Hällo"},
+		{`§[ text | truncate 5 "..." ]§`, "Hällo..."},
+		{`§[ text | truncate 50 "..." ]§`, "Hällo World"},
+		{`§[ published | date "02.01.2006" ]§`, "14.03.2017"},
+		{`§[ timestamp | date "2006-01-02 15:04" ]§`, "2017-03-14 15:09"},
+		{`§[ not_existent | date "2006" ]§`, ""},
+		{`<p data-tags='§[ tags | json ]§'></p>`, `<p data-tags='[&#34;a&#34;,&#34;b&#34;]'></p>`},
+		{`<script>var tags = §[ tags | json ]§;</script>`, `<script>var tags = ["a","b"];</script>`},
+		{`§[ name | upper | raw ]§`, "LIB COMPOSE"},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil)
+		a.NoError(err, test.template)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_TemplateFilters_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	for _, template := range []string{
+		`§[ name | not_existent_filter ]§`,
+		`§[ name | truncate ]§`,
+		`§[ name | truncate x ]§`,
+		`§[ name | default ]§`,
+		`§[ name | date "2006" ]§`,
+		`§[ name | ]§`,
+	} {
+		err := executeTemplate(bytes.NewBufferString(""), template, map[string]interface{}{"name": "foo"}, nil)
+		a.Error(err, template)
+	}
+}
+
+func Test_TemplateFilters_Register(t *testing.T) {
+	a := assert.New(t)
+
+	RegisterTemplateFilter("reverse", func(value interface{}, args []string) (interface{}, error) {
+		runes := []rune(valueToString(value))
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	})
+	RegisterTemplateFilter("fail", func(value interface{}, args []string) (interface{}, error) {
+		return nil, errors.New("some error")
+	})
+	RegisterTemplateFilter("trusted", func(value interface{}, args []string) (interface{}, error) {
+		return SafeString("<b>" + strings.Join(args, " ") + "</b>"), nil
+	})
+
+	buf := bytes.NewBufferString("")
+	err := executeTemplate(buf, `§[ name | reverse ]§ §[ name | trusted some args ]§`, map[string]interface{}{"name": "<abc>"}, nil)
+	a.NoError(err)
+	a.Equal("&gt;cba&lt; <b>some args</b>", buf.String())
+
+	err = executeTemplate(bytes.NewBufferString(""), `§[ name | fail ]§`, nil, nil)
+	a.EqualError(err, `error in template filter "fail": some error`)
+}
+
+func Test_TemplateFilters_DateTypes(t *testing.T) {
+	a := assert.New(t)
+
+	date := time.Date(2017, 3, 14, 0, 0, 0, 0, time.UTC)
+	for _, value := range []interface{}{date, date.Unix(), int(date.Unix()), float64(date.Unix()), "2017-03-14T00:00:00Z"} {
+		formatted, err := dateFilter(value, []string{"2006-01-02"})
+		a.NoError(err)
+		a.Equal("2017-03-14", formatted)
+	}
+
+	_, err := dateFilter(true, []string{"2006-01-02"})
+	a.Error(err)
+}
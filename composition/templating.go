@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"reflect"
 	"strings"
 )
 
@@ -14,15 +15,52 @@ const (
 	StartInclude      = ">"
 	StartIncludeBlock = "#>"
 	EndIncludeBlock   = "/"
+	StartCondition    = "?"
+	ElseCondition     = ":?"
+	EndCondition      = "/?"
+	StartLoop         = "*"
+	ElseLoop          = ":*"
+	EndLoop           = "/*"
 )
 
+// MaxLoopIterations limits the number of iterations of a loop,
+// so that a big list in the meta JSON can not blow up the output.
+var MaxLoopIterations = 100
+
+// MaxTotalLoopIterations limits the number of iterations of all loops of a template execution,
+// including the nested loops and the fragments, which are executed with the same htmlContextWriter.
+var MaxTotalLoopIterations = 1000
+
+// templateExecution is the state of the execution of a template, which is shared by the nested templates.
+type templateExecution struct {
+	context        *htmlContext // the html context for the escaping, or nil if no escaping is done
+	loopIterations int          // the number of iterations of all loops
+}
+
 // Write a template to an output stream.
 // The following replacements will be done:
-// §[ aVariable ]§ inserts a variable from the data map
+// §[ aVariable ]§ inserts a variable from the data map, escaped for its html context
+// §[ aVariable | filter arg ]§ inserts a variable, transformed by a pipeline of filters, see RegisterTemplateFilter
 // §[> fragment ]§ executes a nested fragment by executeNestedFragment() and fails on error
 // §[#> fragment ]§ alt text §[/fragment]§ executes a nested fragment by executeNestedFragment().
-//                  On Error, the alternative Text within the block will be executed.
+//                  On Error, the alternative text within the block will be executed as template.
+// §[? condition ]§ text §[:?]§ else text §[/?]§ executes the text, if the condition is true,
+//                  or the optional else text otherwise. See evaluateCondition for the conditions.
+// §[* list as item, index ]§ text §[:*]§ empty text §[/*]§ executes the text for each element of the list,
+//                  with the element and its index as variables, or the optional empty text, if the list is empty.
+// Fragments, which are executed with the same htmlContextWriter, share the html context for the escaping
+// and the limit of the loop iterations, see MaxTotalLoopIterations.
 func executeTemplate(w io.Writer, template string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+	cw, isContextWriter := w.(*htmlContextWriter)
+	if !isContextWriter {
+		cw = newHtmlContextWriter(w)
+	}
+	return executeTemplateInContext(cw, template, data, executeNestedFragment, cw.execution)
+}
+
+// executeTemplateInContext executes a template, where the variables are escaped for the html context of the execution.
+// The context has to be updated by the writer, see htmlContextWriter. No escaping is done, if the context is nil.
+func executeTemplateInContext(w io.Writer, template string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, execution *templateExecution) error {
 	t := template
 	for len(t) > 0 {
 		start := strings.Index(t, PlaceholderStart)
@@ -34,7 +72,30 @@ func executeTemplate(w io.Writer, template string, data map[string]interface{},
 			io.WriteString(w, t[:start])
 			placeholder := t[start+len(PlaceholderStart) : end]
 
-			if strings.HasPrefix(placeholder, StartIncludeBlock) {
+			if strings.HasPrefix(placeholder, StartCondition) {
+				condition := strings.TrimPrefix(placeholder, StartCondition)
+				thenText, elseText, rest, err := splitBlock(t[end+len(PlaceholderEnd):], StartCondition, ElseCondition, EndCondition)
+				if err != nil {
+					return fmt.Errorf("%v: %v", err, template)
+				}
+				text := elseText
+				if evaluateCondition(condition, data) {
+					text = thenText
+				}
+				if err := executeTemplateInContext(w, text, data, executeNestedFragment, execution); err != nil {
+					return err
+				}
+				t = rest
+			} else if strings.HasPrefix(placeholder, StartLoop) {
+				loopText, emptyText, rest, err := splitBlock(t[end+len(PlaceholderEnd):], StartLoop, ElseLoop, EndLoop)
+				if err != nil {
+					return fmt.Errorf("%v: %v", err, template)
+				}
+				if err := executeLoop(w, strings.TrimPrefix(placeholder, StartLoop), loopText, emptyText, data, executeNestedFragment, execution); err != nil {
+					return err
+				}
+				t = rest
+			} else if strings.HasPrefix(placeholder, StartIncludeBlock) {
 				placeholder = strings.TrimSpace(strings.TrimPrefix(placeholder, StartIncludeBlock))
 				blockEndText := PlaceholderStart + EndIncludeBlock + placeholder + PlaceholderEnd
 				blockEndTextPosition := strings.Index(t, blockEndText)
@@ -42,11 +103,14 @@ func executeTemplate(w io.Writer, template string, data map[string]interface{},
 					return fmt.Errorf("Fragment parsing error, missing ending block: %v", blockEndText)
 				}
 				if err := executeNestedFragment(placeholder); err != nil {
-					io.WriteString(w, t[end+len(PlaceholderEnd):blockEndTextPosition])
+					alternative := t[end+len(PlaceholderEnd) : blockEndTextPosition]
+					if err := executeTemplateInContext(w, alternative, data, executeNestedFragment, execution); err != nil {
+						return err
+					}
 				}
 				t = t[blockEndTextPosition+len(blockEndText):]
 			} else {
-				if err := writePlaceholder(w, placeholder, data, executeNestedFragment); err != nil {
+				if err := writePlaceholder(w, placeholder, data, executeNestedFragment, execution.context); err != nil {
 					return err
 				}
 				t = t[end+len(PlaceholderEnd):]
@@ -59,23 +123,183 @@ func executeTemplate(w io.Writer, template string, data map[string]interface{},
 	return nil
 }
 
+// splitBlock splits the text after the start of a block into the text of the block, the else text
+// and the rest after the end of the block. Nested blocks of the same kind are skipped.
+func splitBlock(t string, startPrefix, elseMarker, endMarker string) (blockText, elseText, rest string, err error) {
+	depth := 0
+	elseStart, elseEnd := -1, -1
+	pos := 0
+	for {
+		start := strings.Index(t[pos:], PlaceholderStart)
+		if start == -1 {
+			return "", "", "", fmt.Errorf("Fragment parsing error, missing ending block: %v", PlaceholderStart+endMarker+PlaceholderEnd)
+		}
+		start += pos
+		end := strings.Index(t[start:], PlaceholderEnd)
+		if end == -1 {
+			return "", "", "", fmt.Errorf("Fragment parsing error, missing ending separator")
+		}
+		end += start + len(PlaceholderEnd)
+
+		placeholder := strings.TrimSpace(t[start+len(PlaceholderStart) : end-len(PlaceholderEnd)])
+		switch {
+		case placeholder == endMarker && depth == 0:
+			if elseStart == -1 {
+				return t[:start], "", t[end:], nil
+			}
+			return t[:elseStart], t[elseEnd:start], t[end:], nil
+		case placeholder == endMarker:
+			depth--
+		case placeholder == elseMarker && depth == 0:
+			elseStart, elseEnd = start, end
+		case strings.HasPrefix(placeholder, startPrefix):
+			depth++
+		}
+		pos = end
+	}
+}
+
+// evaluateCondition evaluates a condition on the data map. Supported conditions are:
+// key          true, if the value exists and is not false, 0, "", "false", nil or an empty list or map
+// !key         negation of key
+// key == value true, if the value is equal to the literal, which may be quoted
+// key != value negation of key == value
+func evaluateCondition(condition string, data map[string]interface{}) bool {
+	condition = strings.TrimSpace(condition)
+	for _, operator := range []string{"==", "!="} {
+		if i := strings.Index(condition, operator); i != -1 {
+			value, exist := getDataFromMap(data, strings.TrimSpace(condition[:i]))
+			literal := strings.Trim(strings.TrimSpace(condition[i+len(operator):]), `"'`)
+			equal := exist && value != nil && fmt.Sprintf("%v", value) == literal
+			return equal == (operator == "==")
+		}
+	}
+
+	if strings.HasPrefix(condition, "!") {
+		return !evaluateCondition(condition[1:], data)
+	}
+
+	value, exist := getDataFromMap(data, condition)
+	return exist && isTruthy(value)
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false"
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// executeLoop executes the loop text for each element of the list, limited by MaxLoopIterations
+// and by MaxTotalLoopIterations for all loops of the execution.
+// The definition has the form "list as item, index", where the names of the item and index variables are optional
+// and default to "item" and "index".
+func executeLoop(w io.Writer, definition, loopText, emptyText string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, execution *templateExecution) error {
+	listKey, itemName, indexName := parseLoopDefinition(definition)
+	list, _ := getDataFromMap(data, listKey)
+	items := listItems(list)
+	if len(items) == 0 {
+		return executeTemplateInContext(w, emptyText, data, executeNestedFragment, execution)
+	}
+
+	if len(items) > MaxLoopIterations {
+		items = items[:MaxLoopIterations]
+	}
+	for i, item := range items {
+		if execution.loopIterations >= MaxTotalLoopIterations {
+			return nil
+		}
+		execution.loopIterations++
+		loopData := make(map[string]interface{}, len(data)+2)
+		for k, v := range data {
+			loopData[k] = v
+		}
+		loopData[itemName] = item
+		loopData[indexName] = i
+		if err := executeTemplateInContext(w, loopText, loopData, executeNestedFragment, execution); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseLoopDefinition(definition string) (listKey, itemName, indexName string) {
+	itemName, indexName = "item", "index"
+	parts := strings.SplitN(definition, " as ", 2)
+	listKey = strings.TrimSpace(parts[0])
+	if len(parts) == 2 {
+		names := strings.SplitN(parts[1], ",", 2)
+		if name := strings.TrimSpace(names[0]); name != "" {
+			itemName = name
+		}
+		if len(names) == 2 {
+			if name := strings.TrimSpace(names[1]); name != "" {
+				indexName = name
+			}
+		}
+	}
+	return listKey, itemName, indexName
+}
+
+// listItems returns the elements of a list, or nil if the value is no list
+func listItems(list interface{}) []interface{} {
+	if items, ok := list.([]interface{}); ok {
+		return items
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items
+}
+
+// expandTemplateVars replaces the variables in the template, e.g. an url, without escaping.
 func expandTemplateVars(template string, data map[string]interface{}) (string, error) {
 	buff := bytes.NewBufferString("")
-	err := executeTemplate(buff, template, data, nil)
+	err := executeTemplateInContext(buff, template, data, nil, &templateExecution{})
 	return buff.String(), err
 }
 
-func writePlaceholder(w io.Writer, placeholder string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+func writePlaceholder(w io.Writer, placeholder string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, context *htmlContext) error {
 	placeholder = strings.TrimSpace(placeholder)
 	if strings.HasPrefix(placeholder, StartInclude) {
 		placeholder = strings.TrimSpace(strings.TrimPrefix(placeholder, StartInclude))
 		if err := executeNestedFragment(placeholder); err != nil {
 			return err
 		}
+		return nil
+	}
+
+	pipeline := splitOutsideQuotes(placeholder, '|')
+	d, _ := getDataFromMap(data, pipeline[0])
+	d, err := applyFilters(d, pipeline[1:])
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return nil
+	}
+	if context == nil {
+		io.WriteString(w, valueToString(d))
 	} else {
-		if d, exist := getDataFromMap(data, placeholder); exist {
-			io.WriteString(w, fmt.Sprintf("%v", d))
-		}
+		io.WriteString(w, context.escape(d))
 	}
 	return nil
 }
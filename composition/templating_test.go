@@ -203,3 +203,177 @@ func Test_Templating_ParsingErrors(t *testing.T) {
 		a.Contains(err.Error(), test.expectedErrString)
 	}
 }
+
+func Test_Templating_Conditions(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"feature": map[string]interface{}{"newHeader": true, "oldFooter": false, "variant": "b"},
+		"count":   float64(0),
+		"items":   []interface{}{"a"},
+		"empty":   "",
+		"off":     "false",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"§[?feature.newHeader]§new§[/?]§", "new"},
+		{"§[?feature.oldFooter]§old§[/?]§", ""},
+		{"§[?feature.newHeader]§new§[:?]§old§[/?]§", "new"},
+		{"§[?feature.oldFooter]§old§[:?]§new§[/?]§", "new"},
+		{"§[?!feature.oldFooter]§new§[/?]§", "new"},
+		{"§[? not_existent ]§x§[:?]§y§[/?]§", "y"},
+		{"§[?count]§x§[:?]§y§[/?]§", "y"},
+		{"§[?items]§x§[:?]§y§[/?]§", "x"},
+		{"§[?empty]§x§[:?]§y§[/?]§", "y"},
+		{"§[?off]§x§[:?]§y§[/?]§", "y"},
+		{"§[?feature.variant == b]§B§[:?]§A§[/?]§", "B"},
+		{`§[?feature.variant == "a"]§A§[:?]§B§[/?]§`, "B"},
+		{"§[?feature.variant != a]§not a§[/?]§", "not a"},
+		{"§[?not_existent != a]§not a§[/?]§", "not a"},
+		{"§[?count == 0]§zero§[/?]§", "zero"},
+		{"xxx-§[?feature.newHeader]§§[feature.variant]§§[/?]§-yyy", "xxx-b-yyy"},
+		{"§[?feature.newHeader]§1§[?feature.oldFooter]§2§[:?]§3§[/?]§4§[:?]§5§[/?]§", "134"},
+		{"§[?feature.oldFooter]§1§[?feature.newHeader]§2§[:?]§3§[/?]§4§[:?]§5§[?items]§6§[/?]§§[/?]§", "56"},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil)
+		a.NoError(err, test.template)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_Templating_ConditionWithInclude(t *testing.T) {
+	a := assert.New(t)
+
+	buf := bytes.NewBufferString("")
+	executeNestedFragment := func(nestedFragmentName string) error {
+		io.WriteString(buf, "<"+nestedFragmentName+"/>")
+		return nil
+	}
+	err := executeTemplate(buf, "§[?new]§§[> new-header]§§[:?]§§[#> old-header]§§[/old-header]§§[/?]§",
+		map[string]interface{}{"new": true}, executeNestedFragment)
+
+	a.NoError(err)
+	a.Equal("<new-header/>", buf.String())
+}
+
+func Test_Templating_ConditionErrors(t *testing.T) {
+	a := assert.New(t)
+
+	for _, template := range []string{
+		"§[?foo]§ no end",
+		"§[?foo]§ §[?bar]§ §[/?]§",
+		"§[?foo]§ §[/?",
+	} {
+		err := executeTemplate(bytes.NewBufferString(""), template, nil, nil)
+		a.Error(err, template)
+	}
+}
+
+func Test_Templating_Loops(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"categories": []interface{}{"animal", "human"},
+		"teasers": []interface{}{
+			map[string]interface{}{"title": "first", "tags": []interface{}{"a", "b"}},
+			map[string]interface{}{"title": "second", "tags": []interface{}{}},
+		},
+		"strings": []string{"x", "y"},
+		"empty":   []interface{}{},
+		"item":    "outer",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"§[*categories]§<li>§[item]§</li>§[/*]§", "<li>animal</li><li>human</li>"},
+		{"§[*categories as category, i]§§[i]§:§[category]§ §[/*]§", "0:animal 1:human "},
+		{"§[* categories as category ]§§[category]§-§[index]§ §[/*]§", "animal-0 human-1 "},
+		{"§[*strings]§§[item]§§[/*]§", "xy"},
+		{"§[*empty]§§[item]§§[:*]§no items§[/*]§", "no items"},
+		{"§[*not_existent]§§[item]§§[:*]§no items§[/*]§", "no items"},
+		{"§[*item]§§[item]§§[:*]§no list§[/*]§", "no list"},
+		{"§[*categories]§§[/*]§§[item]§", "outer"},
+		{"§[*teasers as teaser]§<h2>§[teaser.title]§</h2>§[*teaser.tags as tag]§§[tag]§§[:*]§-§[/*]§;§[/*]§",
+			"<h2>first</h2>ab;<h2>second</h2>-;"},
+		{"§[*categories as c]§§[?c == human]§H§[:?]§§[c]§ §[/?]§§[/*]§", "animal H"},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil)
+		a.NoError(err, test.template)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_Templating_LoopBounds(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(max int) { MaxLoopIterations = max }(MaxLoopIterations)
+	MaxLoopIterations = 3
+
+	list := make([]interface{}, 1000)
+	for i := range list {
+		list[i] = i
+	}
+
+	buf := bytes.NewBufferString("")
+	err := executeTemplate(buf, "§[*list]§§[item]§,§[/*]§", map[string]interface{}{"list": list}, nil)
+	a.NoError(err)
+	a.Equal("0,1,2,", buf.String())
+}
+
+func Test_Templating_NestedLoopBounds(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(max int) { MaxTotalLoopIterations = max }(MaxTotalLoopIterations)
+	MaxTotalLoopIterations = 5
+
+	list := []interface{}{1, 2, 3}
+
+	buf := bytes.NewBufferString("")
+	err := executeTemplate(buf, "§[*list as outer]§[§[*list as inner]§§[inner]§§[/*]§]§[/*]§", map[string]interface{}{"list": list}, nil)
+	a.NoError(err)
+	a.Equal("[123][]", buf.String())
+}
+
+func Test_Templating_LoopBoundsOfNestedFragments(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(max int) { MaxTotalLoopIterations = max }(MaxTotalLoopIterations)
+	MaxTotalLoopIterations = 4
+
+	data := map[string]interface{}{"list": []interface{}{1, 2, 3}}
+	buf := newHtmlContextWriter(bytes.NewBufferString(""))
+	var executeNestedFragment func(nestedFragmentName string) error
+	executeNestedFragment = func(nestedFragmentName string) error {
+		return executeTemplate(buf, "§[*list]§§[item]§§[/*]§", data, executeNestedFragment)
+	}
+	err := executeTemplate(buf, "§[> a]§§[> b]§", data, executeNestedFragment)
+	a.NoError(err)
+	a.Equal("1231", buf.w.(*bytes.Buffer).String())
+}
+
+func Test_Templating_LoopWithInclude(t *testing.T) {
+	a := assert.New(t)
+
+	buf := bytes.NewBufferString("")
+	executeNestedFragment := func(nestedFragmentName string) error {
+		io.WriteString(buf, "<"+nestedFragmentName+"/>")
+		return nil
+	}
+	err := executeTemplate(buf, "§[*list]§§[> teaser]§§[/*]§", map[string]interface{}{"list": []interface{}{1, 2}}, executeNestedFragment)
+	a.NoError(err)
+	a.Equal("<teaser/><teaser/>", buf.String())
+
+	err = executeTemplate(bytes.NewBufferString(""), "§[*list]§ no end", nil, nil)
+	a.Error(err)
+}
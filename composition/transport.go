@@ -0,0 +1,173 @@
+package composition
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportSettings configure the connection pool of the HttpContentLoaders.
+type TransportSettings struct {
+	// MaxIdleConns is the maximum number of idle connections to all hosts, 0 means no limit.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections to one host.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is the duration, after which idle connections are closed.
+	IdleConnTimeout time.Duration
+
+	// KeepAlive is the interval of tcp keep alive probes, a negative value disables them.
+	KeepAlive time.Duration
+
+	// DialTimeout is the maximum duration for establishing a connection.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout is the maximum duration for the tls handshake.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSClientConfig is the tls configuration for https backends, e.g. for custom root certificates.
+	TLSClientConfig *tls.Config
+
+	// EnableHTTP2 allows http/2 to https backends.
+	EnableHTTP2 bool
+}
+
+// DefaultTransportSettings are tuned for a fan out of many fragments to a few hosts.
+var DefaultTransportSettings = TransportSettings{
+	MaxIdleConns:        500,
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+	KeepAlive:           30 * time.Second,
+	DialTimeout:         5 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+}
+
+// DefaultTransport is shared by all HttpContentLoaders, created by NewHttpContentLoader.
+var DefaultTransport = NewTransport(DefaultTransportSettings)
+
+// ConnectionStats are the statistics of the connections of a Transport.
+type ConnectionStats struct {
+	// Dials is the number of established connections.
+	Dials int64 `json:"dials"`
+
+	// DialErrors is the number of connections, which could not be established.
+	DialErrors int64 `json:"dial_errors"`
+
+	// OpenConnections is the number of currently open connections, idle or in use.
+	OpenConnections int64 `json:"open_connections"`
+
+	// Requests is the number of requests, which got a connection.
+	Requests int64 `json:"requests"`
+
+	// ReusedConnections is the number of requests, which got an already established connection.
+	ReusedConnections int64 `json:"reused_connections"`
+}
+
+// Transport is a connection pool, which is shared by HttpContentLoaders.
+type Transport struct {
+	client *http.Client
+	trace  *httptrace.ClientTrace
+	stats  ConnectionStats
+}
+
+type followRedirectsKey struct{}
+
+// NewTransport creates a connection pool with the settings.
+func NewTransport(settings TransportSettings) *Transport {
+	t := &Transport{}
+	dialer := &net.Dialer{
+		Timeout:   settings.DialTimeout,
+		KeepAlive: settings.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         t.dialContext(dialer),
+		MaxIdleConns:        settings.MaxIdleConns,
+		MaxIdleConnsPerHost: settings.MaxIdleConnsPerHost,
+		IdleConnTimeout:     settings.IdleConnTimeout,
+		TLSHandshakeTimeout: settings.TLSHandshakeTimeout,
+		TLSClientConfig:     settings.TLSClientConfig,
+		ForceAttemptHTTP2:   settings.EnableHTTP2,
+	}
+
+	t.client = &http.Client{
+		Transport: transport,
+		// redirects can only be stopped by returning an error in the CheckRedirect function
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if follow, _ := req.Context().Value(followRedirectsKey{}).(bool); !follow {
+				return redirectAttemptedError
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+
+	t.trace = &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			atomic.AddInt64(&t.stats.Requests, 1)
+			if info.Reused {
+				atomic.AddInt64(&t.stats.ReusedConnections, 1)
+			}
+		},
+	}
+	return t
+}
+
+// Stats returns the statistics of the connections.
+func (t *Transport) Stats() ConnectionStats {
+	return ConnectionStats{
+		Dials:             atomic.LoadInt64(&t.stats.Dials),
+		DialErrors:        atomic.LoadInt64(&t.stats.DialErrors),
+		OpenConnections:   atomic.LoadInt64(&t.stats.OpenConnections),
+		Requests:          atomic.LoadInt64(&t.stats.Requests),
+		ReusedConnections: atomic.LoadInt64(&t.stats.ReusedConnections),
+	}
+}
+
+// CloseIdleConnections closes the connections, which are not in use.
+func (t *Transport) CloseIdleConnections() {
+	t.client.Transport.(*http.Transport).CloseIdleConnections()
+}
+
+// do sends the request with the connection pool.
+func (t *Transport) do(request *http.Request, followRedirects bool) (*http.Response, error) {
+	ctx := context.WithValue(request.Context(), followRedirectsKey{}, followRedirects)
+	ctx = httptrace.WithClientTrace(ctx, t.trace)
+	return t.client.Do(request.WithContext(ctx))
+}
+
+func (t *Transport) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			atomic.AddInt64(&t.stats.DialErrors, 1)
+			return nil, err
+		}
+		atomic.AddInt64(&t.stats.Dials, 1)
+		atomic.AddInt64(&t.stats.OpenConnections, 1)
+		return &countedConn{Conn: conn, stats: &t.stats}, nil
+	}
+}
+
+// countedConn counts the open connections.
+type countedConn struct {
+	net.Conn
+	stats     *ConnectionStats
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&c.stats.OpenConnections, -1)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,124 @@
+package composition
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Transport_ReusesConnections(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Hello</body></html>"))
+	}))
+	defer server.Close()
+
+	transport := NewTransport(DefaultTransportSettings)
+	defer transport.CloseIdleConnections()
+	loader := NewHttpContentLoaderWithTransport(transport)
+
+	// when
+	for i := 0; i < 3; i++ {
+		_, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
+		a.NoError(err)
+	}
+
+	// then
+	stats := transport.Stats()
+	a.Equal(int64(1), stats.Dials)
+	a.Equal(int64(3), stats.Requests)
+	a.Equal(int64(2), stats.ReusedConnections)
+	a.Equal(int64(1), stats.OpenConnections)
+
+	transport.CloseIdleConnections()
+	a.Equal(int64(0), transport.Stats().OpenConnections)
+}
+
+func Test_Transport_ReusesConnectionsAfterErrorStatus(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write([]byte("some error page"))
+	}))
+	defer server.Close()
+
+	transport := NewTransport(DefaultTransportSettings)
+	defer transport.CloseIdleConnections()
+	loader := NewHttpContentLoaderWithTransport(transport)
+
+	// when
+	for i := 0; i < 2; i++ {
+		_, err := loader.Load(context.Background(), NewFetchDefinition(server.URL))
+		a.Error(err)
+	}
+
+	// then
+	a.Equal(int64(1), transport.Stats().Dials)
+}
+
+func Test_Transport_FollowRedirects(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", 302)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Target</body></html>"))
+	}))
+	defer server.Close()
+
+	transport := NewTransport(DefaultTransportSettings)
+	defer transport.CloseIdleConnections()
+	loader := NewHttpContentLoaderWithTransport(transport)
+
+	// when
+	fd := NewFetchDefinition(server.URL + "/redirect")
+	c, err := loader.Load(context.Background(), fd)
+
+	// then
+	a.NoError(err)
+	a.Equal(302, c.HttpStatusCode())
+
+	// when
+	fd.FollowRedirects = true
+	c, err = loader.Load(context.Background(), fd)
+
+	// then
+	a.NoError(err)
+	a.Equal(200, c.HttpStatusCode())
+}
+
+func Test_Transport_Timeout(t *testing.T) {
+	a := assert.New(t)
+
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	transport := NewTransport(DefaultTransportSettings)
+	defer transport.CloseIdleConnections()
+	loader := NewHttpContentLoaderWithTransport(transport)
+
+	// when
+	fd := NewFetchDefinition(server.URL)
+	fd.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := loader.Load(context.Background(), fd)
+
+	// then
+	a.Error(err)
+	a.True(time.Since(start) < 150*time.Millisecond)
+}
@@ -29,7 +29,7 @@ func compositionHandler() http.Handler {
 			"request":     composition.MetadataForRequest(r),
 		}
 
-		fetcher := composition.NewContentFetcher(defaultMetaJSON)
+		fetcher := composition.NewContentFetcherWithContext(r.Context(), defaultMetaJSON)
 
 		// defines the 'teaser' fd for lazy fetching
 		fetcher.SetFetchDefinitionFactory(NewLazyFdFactory(r).getFetchDefinitions)
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Recorder receives the measures of the composition.
+// Implementations have to be safe for concurrent use.
+type Recorder interface {
+	// CacheHit counts a lookup, which found a fresh entry in the cache.
+	CacheHit(cache string)
+
+	// CacheMiss counts a lookup, which found no fresh entry in the cache.
+	CacheMiss(cache string)
+
+	// CacheEviction counts entries, which were removed, because the cache was full.
+	CacheEviction(cache string, count int)
+
+	// CacheSize reports the current number of entries and the memory size of the cache.
+	CacheSize(cache string, entries int, sizeBytes int)
+
+	// Fetch reports the duration and status code of a backend request.
+	// The status code is 0, if no response was received.
+	Fetch(backend string, statusCode int, duration time.Duration)
+
+	// Parse reports the duration of parsing a fetched content.
+	Parse(duration time.Duration)
+
+	// Merge reports the duration of merging the contents to one page.
+	Merge(duration time.Duration)
+
+	// RequiredFetchFailed counts a failed fetch, which is required for the page.
+	RequiredFetchFailed(backend string)
+}
+
+// Default is the Recorder, which is used by the library.
+// It discards all measures, until another Recorder is set.
+var Default Recorder = NopRecorder{}
+
+// NopRecorder is a Recorder, which discards all measures.
+type NopRecorder struct{}
+
+func (NopRecorder) CacheHit(cache string)                                        {}
+func (NopRecorder) CacheMiss(cache string)                                       {}
+func (NopRecorder) CacheEviction(cache string, count int)                        {}
+func (NopRecorder) CacheSize(cache string, entries int, sizeBytes int)           {}
+func (NopRecorder) Fetch(backend string, statusCode int, duration time.Duration) {}
+func (NopRecorder) Parse(duration time.Duration)                                 {}
+func (NopRecorder) Merge(duration time.Duration)                                 {}
+func (NopRecorder) RequiredFetchFailed(backend string)                           {}
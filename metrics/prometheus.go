@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the duration histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counter   metricType = "counter"
+	gauge     metricType = "gauge"
+	histogram metricType = "histogram"
+)
+
+type descriptor struct {
+	name       string
+	metricType metricType
+	help       string
+}
+
+const (
+	cacheHits           = "lib_compose_cache_hits_total"
+	cacheMisses         = "lib_compose_cache_misses_total"
+	cacheEvictions      = "lib_compose_cache_evictions_total"
+	cacheEntries        = "lib_compose_cache_entries"
+	cacheSizeBytes      = "lib_compose_cache_size_bytes"
+	fetchDuration       = "lib_compose_fetch_duration_seconds"
+	fetchResponses      = "lib_compose_fetch_responses_total"
+	parseDuration       = "lib_compose_parse_duration_seconds"
+	mergeDuration       = "lib_compose_merge_duration_seconds"
+	requiredFetchErrors = "lib_compose_required_fetch_failures_total"
+)
+
+// descriptors are the exposed metrics in the order of the output
+var descriptors = []descriptor{
+	{cacheHits, counter, "Number of cache lookups, which found a fresh entry."},
+	{cacheMisses, counter, "Number of cache lookups, which found no fresh entry."},
+	{cacheEvictions, counter, "Number of cache entries removed, because the cache was full."},
+	{cacheEntries, gauge, "Number of entries in the cache."},
+	{cacheSizeBytes, gauge, "Memory size of the cache entries."},
+	{fetchDuration, histogram, "Duration of the backend requests."},
+	{fetchResponses, counter, "Number of backend responses by status code, 0 if no response was received."},
+	{parseDuration, histogram, "Duration of parsing the fetched contents."},
+	{mergeDuration, histogram, "Duration of merging the contents to one page."},
+	{requiredFetchErrors, counter, "Number of failed fetches, which were required for the page."},
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// PrometheusRecorder collects the measures and serves them in the Prometheus text format.
+type PrometheusRecorder struct {
+	buckets    []float64
+	mutex      sync.Mutex
+	values     map[string]map[string]float64 // the counters and gauges by name and labels
+	histograms map[string]map[string]*histogramValue
+}
+
+// NewPrometheusRecorder creates a PrometheusRecorder with the DefaultBuckets.
+func NewPrometheusRecorder() *PrometheusRecorder {
+	return NewPrometheusRecorderWithBuckets(DefaultBuckets)
+}
+
+// NewPrometheusRecorderWithBuckets creates a PrometheusRecorder with the upper bounds of the histograms in seconds.
+func NewPrometheusRecorderWithBuckets(buckets []float64) *PrometheusRecorder {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &PrometheusRecorder{
+		buckets:    sorted,
+		values:     make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogramValue),
+	}
+}
+
+func (p *PrometheusRecorder) CacheHit(cache string) {
+	p.add(cacheHits, labels("cache", cache), 1)
+}
+
+func (p *PrometheusRecorder) CacheMiss(cache string) {
+	p.add(cacheMisses, labels("cache", cache), 1)
+}
+
+func (p *PrometheusRecorder) CacheEviction(cache string, count int) {
+	p.add(cacheEvictions, labels("cache", cache), float64(count))
+}
+
+func (p *PrometheusRecorder) CacheSize(cache string, entries int, sizeBytes int) {
+	p.set(cacheEntries, labels("cache", cache), float64(entries))
+	p.set(cacheSizeBytes, labels("cache", cache), float64(sizeBytes))
+}
+
+func (p *PrometheusRecorder) Fetch(backend string, statusCode int, duration time.Duration) {
+	p.observe(fetchDuration, labels("backend", backend), duration)
+	p.add(fetchResponses, labels("backend", backend, "status", strconv.Itoa(statusCode)), 1)
+}
+
+func (p *PrometheusRecorder) Parse(duration time.Duration) {
+	p.observe(parseDuration, "", duration)
+}
+
+func (p *PrometheusRecorder) Merge(duration time.Duration) {
+	p.observe(mergeDuration, "", duration)
+}
+
+func (p *PrometheusRecorder) RequiredFetchFailed(backend string) {
+	p.add(requiredFetchErrors, labels("backend", backend), 1)
+}
+
+func (p *PrometheusRecorder) add(name string, labels string, delta float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.series(name)[labels] += delta
+}
+
+func (p *PrometheusRecorder) set(name string, labels string, value float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.series(name)[labels] = value
+}
+
+// series returns the values of a counter or gauge.
+// Attention: This method does not locking.
+func (p *PrometheusRecorder) series(name string) map[string]float64 {
+	if _, exist := p.values[name]; !exist {
+		p.values[name] = make(map[string]float64)
+	}
+	return p.values[name]
+}
+
+func (p *PrometheusRecorder) observe(name string, labels string, duration time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, exist := p.histograms[name]; !exist {
+		p.histograms[name] = make(map[string]*histogramValue)
+	}
+	h, exist := p.histograms[name][labels]
+	if !exist {
+		h = &histogramValue{counts: make([]uint64, len(p.buckets))}
+		p.histograms[name][labels] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, upperBound := range p.buckets {
+		if seconds <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format.
+func (p *PrometheusRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(p.Text())
+}
+
+// Text returns all metrics in the Prometheus text format.
+func (p *PrometheusRecorder) Text() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	buff := &bytes.Buffer{}
+	for _, d := range descriptors {
+		fmt.Fprintf(buff, "# HELP %v %v\n", d.name, d.help)
+		fmt.Fprintf(buff, "# TYPE %v %v\n", d.name, d.metricType)
+		if d.metricType == histogram {
+			for _, l := range sortedKeys(p.histograms[d.name]) {
+				h := p.histograms[d.name][l]
+				for i, upperBound := range p.buckets {
+					fmt.Fprintf(buff, "%v_bucket{%v} %v\n", d.name, withLabel(l, "le", formatFloat(upperBound)), h.counts[i])
+				}
+				fmt.Fprintf(buff, "%v_bucket{%v} %v\n", d.name, withLabel(l, "le", "+Inf"), h.count)
+				fmt.Fprintf(buff, "%v_sum%v %v\n", d.name, braced(l), formatFloat(h.sum))
+				fmt.Fprintf(buff, "%v_count%v %v\n", d.name, braced(l), h.count)
+			}
+		} else {
+			for _, l := range sortedKeys(p.values[d.name]) {
+				fmt.Fprintf(buff, "%v%v %v\n", d.name, braced(l), formatFloat(p.values[d.name][l]))
+			}
+		}
+	}
+	return buff.Bytes()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels returns the formatted label pairs of the names and values.
+func labels(nameValuePairs ...string) string {
+	pairs := make([]string, 0, len(nameValuePairs)/2)
+	for i := 0; i+1 < len(nameValuePairs); i += 2 {
+		pairs = append(pairs, nameValuePairs[i]+`="`+labelValueEscaper.Replace(nameValuePairs[i+1])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func withLabel(formattedLabels string, name string, value string) string {
+	if formattedLabels == "" {
+		return labels(name, value)
+	}
+	return formattedLabels + "," + labels(name, value)
+}
+
+func braced(formattedLabels string) string {
+	if formattedLabels == "" {
+		return ""
+	}
+	return "{" + formattedLabels + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch typed := m.(type) {
+	case map[string]float64:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramValue:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PrometheusRecorder_Text(t *testing.T) {
+	a := assert.New(t)
+
+	// given a recorder with some measures
+	p := NewPrometheusRecorderWithBuckets([]float64{1, 0.1})
+	p.CacheHit("fragments")
+	p.CacheHit("fragments")
+	p.CacheMiss("fragments")
+	p.CacheEviction("fragments", 3)
+	p.CacheSize("fragments", 10, 2048)
+	p.Fetch("example.de", 200, time.Millisecond*50)
+	p.Fetch("example.de", 200, time.Millisecond*500)
+	p.Fetch("example.org", 0, time.Second*2)
+	p.Parse(time.Millisecond)
+	p.RequiredFetchFailed("example.org")
+
+	// when the text is created
+	text := string(p.Text())
+
+	// then it contains the metrics in the prometheus format
+	for _, line := range []string{
+		"# TYPE lib_compose_cache_hits_total counter",
+		`lib_compose_cache_hits_total{cache="fragments"} 2`,
+		`lib_compose_cache_misses_total{cache="fragments"} 1`,
+		`lib_compose_cache_evictions_total{cache="fragments"} 3`,
+		`lib_compose_cache_entries{cache="fragments"} 10`,
+		`lib_compose_cache_size_bytes{cache="fragments"} 2048`,
+		"# TYPE lib_compose_fetch_duration_seconds histogram",
+		`lib_compose_fetch_duration_seconds_bucket{backend="example.de",le="0.1"} 1`,
+		`lib_compose_fetch_duration_seconds_bucket{backend="example.de",le="1"} 2`,
+		`lib_compose_fetch_duration_seconds_bucket{backend="example.de",le="+Inf"} 2`,
+		`lib_compose_fetch_duration_seconds_sum{backend="example.de"} 0.55`,
+		`lib_compose_fetch_duration_seconds_count{backend="example.de"} 2`,
+		`lib_compose_fetch_duration_seconds_bucket{backend="example.org",le="1"} 0`,
+		`lib_compose_fetch_responses_total{backend="example.de",status="200"} 2`,
+		`lib_compose_fetch_responses_total{backend="example.org",status="0"} 1`,
+		`lib_compose_parse_duration_seconds_bucket{le="0.1"} 1`,
+		`lib_compose_parse_duration_seconds_count 1`,
+		"# TYPE lib_compose_merge_duration_seconds histogram",
+		`lib_compose_required_fetch_failures_total{backend="example.org"} 1`,
+	} {
+		a.Contains(text, line+"\n")
+	}
+
+	// and the merge duration has no values
+	a.NotContains(text, "lib_compose_merge_duration_seconds_count")
+}
+
+func Test_PrometheusRecorder_EscapesLabels(t *testing.T) {
+	a := assert.New(t)
+
+	p := NewPrometheusRecorder()
+	p.CacheHit("a \"quoted\"\\name\n")
+
+	a.Contains(string(p.Text()), `lib_compose_cache_hits_total{cache="a \"quoted\"\\name\n"} 1`)
+}
+
+func Test_PrometheusRecorder_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	p := NewPrometheusRecorder()
+	p.Merge(time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/metrics", nil)
+	p.ServeHTTP(recorder, request)
+
+	a.Equal(200, recorder.Code)
+	a.True(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	a.Contains(recorder.Body.String(), "lib_compose_merge_duration_seconds_count 1\n")
+}
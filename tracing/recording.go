@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// SpanData is a finished span.
+type SpanData struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID [8]byte
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Err          error
+}
+
+// HasParent returns true, if the span was started as child of another span.
+func (d SpanData) HasParent() bool {
+	return d.ParentSpanID != [8]byte{}
+}
+
+// Exporter receives the finished spans of a RecordingTracer.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// RecordingTracer records all spans and passes them to the Exporter, when they are finished.
+type RecordingTracer struct {
+	exporter Exporter
+}
+
+// NewRecordingTracer creates a RecordingTracer, which exports the spans to the exporter.
+func NewRecordingTracer(exporter Exporter) *RecordingTracer {
+	return &RecordingTracer{exporter: exporter}
+}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	s := &recordingSpan{
+		exporter: t.exporter,
+		data: SpanData{
+			Name:         name,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), s
+}
+
+type recordingSpan struct {
+	exporter Exporter
+	mutex    sync.Mutex
+	ended    bool
+	data     SpanData
+}
+
+func (s *recordingSpan) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Attributes[key] = value
+}
+
+func (s *recordingSpan) SetError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Err = err
+}
+
+// End exports the span, only on the first call.
+func (s *recordingSpan) End() {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mutex.Unlock()
+
+	s.exporter.Export(data)
+}
+
+// InMemoryExporter keeps the finished spans in memory, e.g. for tests.
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the finished spans in the order of their end.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
+
+// SpansByName returns the finished spans with the name.
+func (e *InMemoryExporter) SpansByName(name string) []SpanData {
+	var result []SpanData
+	for _, s := range e.Spans() {
+		if s.Name == name {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Reset removes all spans.
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
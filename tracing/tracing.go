@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader is the W3C Trace Context header, which identifies the calling span.
+const TraceParentHeader = "Traceparent"
+
+var InvalidTraceParent = errors.New("invalid traceparent header")
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true, if the trace and the span id are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the value of the W3C traceparent header for the span.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceParent parses the value of a W3C traceparent header.
+func ParseTraceParent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, InvalidTraceParent
+	}
+	// only version 00 has exactly four parts
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, InvalidTraceParent
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, InvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, InvalidTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, InvalidTraceParent
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, InvalidTraceParent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Span is one timed step of a trace.
+type Span interface {
+	// SpanContext returns the identification of the span.
+	SpanContext() SpanContext
+
+	// SetAttribute adds a key value pair to the span.
+	SetAttribute(key string, value interface{})
+
+	// SetError marks the span as failed.
+	SetError(err error)
+
+	// End finishes the span.
+	End()
+}
+
+// Tracer creates the spans.
+// Implementations have to be safe for concurrent use.
+type Tracer interface {
+	// Start creates a span, which is a child of the span in the context,
+	// and returns a context containing the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Default is the Tracer, which is used by the library.
+// It does not record any spans, until another Tracer is set.
+var Default Tracer = NopTracer{}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of the context, which contains the span context as parent for new spans.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span,
+// or an invalid one, if the context contains no span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Extract returns a copy of the context, which contains the span of the traceparent header, if present.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if sc, err := ParseTraceParent(header.Get(TraceParentHeader)); err == nil {
+		return ContextWithSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header for the span of the context, if it contains one.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		header.Set(TraceParentHeader, sc.TraceParent())
+	}
+}
+
+// NopTracer creates spans, which are not recorded.
+// The spans keep the span context of their parent, so an incoming trace is still propagated.
+type NopTracer struct{}
+
+func (NopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{sc: SpanContextFromContext(ctx)}
+}
+
+type nopSpan struct {
+	sc SpanContext
+}
+
+func (s nopSpan) SpanContext() SpanContext                   { return s.sc }
+func (s nopSpan) SetAttribute(key string, value interface{}) {}
+func (s nopSpan) SetError(err error)                         {}
+func (s nopSpan) End()                                       {}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func Test_ParseTraceParent(t *testing.T) {
+	a := assert.New(t)
+
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	a.NoError(err)
+	a.True(sc.IsValid())
+	a.True(sc.Sampled)
+	a.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	sc, err = ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	a.NoError(err)
+	a.False(sc.Sampled)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future",
+	} {
+		_, err := ParseTraceParent(invalid)
+		a.Equal(InvalidTraceParent, err, invalid)
+	}
+}
+
+func Test_ExtractAndInject(t *testing.T) {
+	a := assert.New(t)
+
+	// given an incoming traceparent
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// when it is extracted and injected by the nop tracer
+	ctx := Extract(context.Background(), incoming)
+	ctx, span := NopTracer{}.Start(ctx, "some step")
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	// then the incoming trace is propagated
+	a.Equal(incoming.Get("traceparent"), span.SpanContext().TraceParent())
+	a.Equal(incoming.Get("traceparent"), outgoing.Get("traceparent"))
+
+	// and nothing is injected without a span
+	outgoing = http.Header{}
+	Inject(context.Background(), outgoing)
+	a.Equal("", outgoing.Get("traceparent"))
+}
+
+func Test_RecordingTracer(t *testing.T) {
+	a := assert.New(t)
+
+	// given a tracer with an in memory exporter
+	exporter := NewInMemoryExporter()
+	tracer := NewRecordingTracer(exporter)
+
+	// when spans are nested
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("url", "http://example.de")
+	child.SetError(errors.New("some error"))
+	child.End()
+	child.End()
+	parent.End()
+
+	// then they are exported once, when they are ended
+	spans := exporter.Spans()
+	a.Equal(2, len(spans))
+	a.Equal("child", spans[0].Name)
+	a.Equal("parent", spans[1].Name)
+
+	// and the child belongs to the trace of the parent
+	a.False(spans[1].HasParent())
+	a.True(spans[0].HasParent())
+	a.Equal(spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID)
+	a.Equal(spans[1].SpanContext.SpanID, spans[0].ParentSpanID)
+	a.NotEqual(spans[1].SpanContext.SpanID, spans[0].SpanContext.SpanID)
+
+	// and contains the attributes and the error
+	a.Equal("http://example.de", spans[0].Attributes["url"])
+	a.EqualError(spans[0].Err, "some error")
+	a.False(spans[0].End.Before(spans[0].Start))
+
+	a.Equal(1, len(exporter.SpansByName("parent")))
+	exporter.Reset()
+	a.Equal(0, len(exporter.Spans()))
+}
+
+func Test_RecordingTracer_ContinuesRemoteTrace(t *testing.T) {
+	a := assert.New(t)
+
+	exporter := NewInMemoryExporter()
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, span := NewRecordingTracer(exporter).Start(ContextWithSpanContext(context.Background(), remote), "step")
+	span.End()
+
+	a.Equal(remote.TraceID, exporter.Spans()[0].SpanContext.TraceID)
+	a.Equal(remote.SpanID, exporter.Spans()[0].ParentSpanID)
+}